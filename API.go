@@ -10,6 +10,29 @@ package fridago
  void _on_message(FridaScript * script, const gchar * message, GBytes * data, gpointer user_data) {
      onMessage(script, message, data, user_data);
  }
+ gulong _connect_message(FridaScript * script, guint handle) {
+     return g_signal_connect_data(script, "message", G_CALLBACK(_on_message),
+                                  GUINT_TO_POINTER(handle), NULL, 0);
+ }
+ void _on_script_destroyed(FridaScript * script, gpointer user_data) {
+     onScriptDestroyed(script, user_data);
+ }
+ gulong _connect_signal(gpointer instance, const gchar * sig, GCallback cb, guint handle) {
+     return g_signal_connect_data(instance, sig, cb, GUINT_TO_POINTER(handle), NULL, 0);
+ }
+ // g_object_ref is a macro cgo cannot call
+ gpointer _ref_object(gpointer obj) {
+     return g_object_ref(obj);
+ }
+ void _on_device_added(FridaDeviceManager * manager, FridaDevice * device, gpointer user_data) {
+     onDeviceAdded(manager, device, user_data);
+ }
+ void _on_device_removed(FridaDeviceManager * manager, FridaDevice * device, gpointer user_data) {
+     onDeviceRemoved(manager, device, user_data);
+ }
+ void _on_devices_changed(FridaDeviceManager * manager, gpointer user_data) {
+     onDevicesChanged(manager, user_data);
+ }
  void _on_spawn_added(FridaDevice * device, FridaSpawn * spawn, gpointer user_data) {
      onSpawnAdded(device, spawn, user_data);
  }
@@ -27,6 +50,7 @@ package fridago
 import "C"
 import (
 	"os"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -101,7 +125,10 @@ func Attach(target string) (sess *Session, err error) {
 		"target": target,
 	}).Debug("attach")
 
-	d, _ := GetLocalDevice()
+	d, err := GetLocalDevice()
+	if err != nil {
+		return
+	}
 	log.WithFields(logrus.Fields{
 		"name": d.Name,
 		"id":   d.ID,
@@ -115,42 +142,76 @@ func Attach(target string) (sess *Session, err error) {
 	return d.Attach(p.Pid)
 }
 
+// DefaultDeviceTimeout bounds how long GetDevice and the Get*Device
+// helpers wait for a device to appear.
+var DefaultDeviceTimeout = 10 * time.Second
+
+func defaultDeviceTimeoutMs() int {
+	return int(DefaultDeviceTimeout / time.Millisecond)
+}
+
 func EnumerateDevices() ([]*Device, error) {
-	dm := GetDeviceManager()
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
+	}
 	return dm.EnumerateDevicesSync()
 }
 
 func GetDevice(id string) (*Device, error) {
-	dm := GetDeviceManager()
-	return dm.GetDeviceById(id, 10)
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
+	}
+	return dm.GetDeviceById(id, defaultDeviceTimeoutMs())
 }
 
-func GetDeviceMatching(predicate func(*Device) bool, timeout time.Duration) (dl []*Device, err error) {
-	devices, err := EnumerateDevices()
+func GetDeviceMatching(predicate func(*Device) bool, timeout time.Duration) (*Device, error) {
+	dm, err := GetDeviceManager()
 	if err != nil {
-		return
+		return nil, err
 	}
-	for i, d := range devices {
-		if predicate(d) {
-			dl = append(dl, devices[i])
-		}
+	return dm.GetDeviceMatching(predicate, timeout)
+}
+
+func FindDeviceById(id string) (*Device, error) {
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
 	}
-	return
+	return dm.FindDeviceById(id)
+}
+
+func FindDeviceByType(dtype uint) (*Device, error) {
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
+	}
+	return dm.FindDeviceByType(dtype)
 }
 
 func GetLocalDevice() (*Device, error) {
-	dm := GetDeviceManager()
-	return dm.GetDeviceByType(C.FRIDA_DEVICE_TYPE_LOCAL, 10)
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
+	}
+	return dm.GetDeviceByType(C.FRIDA_DEVICE_TYPE_LOCAL, defaultDeviceTimeoutMs())
 }
 
 func GetRemoteDevice() (*Device, error) {
-	dm := GetDeviceManager()
-	return dm.GetDeviceByType(C.FRIDA_DEVICE_TYPE_REMOTE, 10)
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
+	}
+	return dm.GetDeviceByType(C.FRIDA_DEVICE_TYPE_REMOTE, defaultDeviceTimeoutMs())
 }
 
 func GetUsbDevice() (*Device, error) {
-	dm := GetDeviceManager()
-	return dm.GetDeviceByType(C.FRIDA_DEVICE_TYPE_USB, 10)
+	dm, err := GetDeviceManager()
+	if err != nil {
+		return nil, err
+	}
+	return dm.GetDeviceByType(C.FRIDA_DEVICE_TYPE_USB, defaultDeviceTimeoutMs())
 }
 
 // inject_library_blob(target, blob, entrypoint, data)
@@ -160,11 +221,30 @@ func GetUsbDevice() (*Device, error) {
 // shutdown()
 // spawn(*args, **kwargs)
 
-var deviceManager *DeviceManager
+var (
+	deviceManagerMu sync.Mutex
+	deviceManager   *DeviceManager
+)
 
-func GetDeviceManager() *DeviceManager {
+// GetDeviceManager returns the shared device manager, creating it on first
+// use or after it has been closed.
+func GetDeviceManager() (*DeviceManager, error) {
+	deviceManagerMu.Lock()
+	defer deviceManagerMu.Unlock()
 	if deviceManager == nil {
-		deviceManager, _ = NewDeviceManager()
+		dm, err := NewDeviceManager()
+		if err != nil {
+			return nil, err
+		}
+		deviceManager = dm
+	}
+	return deviceManager, nil
+}
+
+func releaseDeviceManager(dm *DeviceManager) {
+	deviceManagerMu.Lock()
+	defer deviceManagerMu.Unlock()
+	if deviceManager == dm {
+		deviceManager = nil
 	}
-	return deviceManager
 }
@@ -0,0 +1,211 @@
+package fridago
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// agentFS holds the library's own agent: agent.js sets up the request
+// dispatcher and every other file registers its operations on it.
+//
+//go:embed js/agent/*.js
+var agentFS embed.FS
+
+// DefaultAgentTimeout bounds each request to the embedded agent.
+var DefaultAgentTimeout = 60 * time.Second
+
+var agentReqIDNum uint64 = 0
+
+func agentSource() (string, error) {
+	entries, err := agentFS.ReadDir("js/agent")
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Name() != "agent.js" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{"agent.js"}, names...)
+
+	var b strings.Builder
+	for _, name := range names {
+		src, err := agentFS.ReadFile("js/agent/" + name)
+		if err != nil {
+			return "", err
+		}
+		b.Write(src)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// agentScript returns the session's embedded agent, loading it on first use.
+func (sess *Session) agentScript() (scr *Script, err error) {
+	sess.agentMu.Lock()
+	defer sess.agentMu.Unlock()
+	if sess.agent != nil {
+		return sess.agent, nil
+	}
+	src, err := agentSource()
+	if err != nil {
+		return
+	}
+	scr, err = sess.CreateScriptSync("fridago-agent", src)
+	if err != nil {
+		return
+	}
+	if scr == nil {
+		return nil, NewErrorAndLog("Session: create agent failed")
+	}
+	if err = scr.Load(); err != nil {
+		scr.UnLoad()
+		return nil, err
+	}
+	sess.agent = scr
+	return
+}
+
+// agentRequest is one operation in flight on the embedded agent. Replies
+// and events arrive on results. The dispatcher only ever pushes to queue,
+// so a slow reader never holds up the script's other messages.
+type agentRequest struct {
+	scr     *Script
+	id      string
+	queue   *eventQueue[*rpcResult]
+	results chan *rpcResult
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (sess *Session) agentStart(op string, args interface{}, data []byte) (req *agentRequest, err error) {
+	scr, err := sess.agentScript()
+	if err != nil {
+		return
+	}
+	req = &agentRequest{
+		scr:     scr,
+		id:      fmt.Sprintf("agent_%d", atomic.AddUint64(&agentReqIDNum, 1)),
+		queue:   newEventQueue[*rpcResult](),
+		results: make(chan *rpcResult),
+		done:    make(chan struct{}),
+	}
+	scr.rpcCalls.Store(req.id, req.queue)
+	go req.forward()
+	err = scr.PostJSON(map[string]interface{}{
+		"type": "frida:agent",
+		"id":   req.id,
+		"op":   op,
+		"args": args,
+	}, data)
+	if err != nil {
+		req.finish()
+		return nil, err
+	}
+	return
+}
+
+func (req *agentRequest) forward() {
+	for {
+		res, ok := req.queue.pop()
+		if !ok {
+			return
+		}
+		select {
+		case req.results <- res:
+		case <-req.done:
+			return
+		}
+	}
+}
+
+func (req *agentRequest) finish() {
+	req.once.Do(func() {
+		req.scr.rpcCalls.Delete(req.id)
+		req.queue.close()
+		close(req.done)
+	})
+}
+
+// cancel asks the agent to stop the operation at its next check.
+func (req *agentRequest) cancel() error {
+	return req.scr.PostJSON(map[string]interface{}{
+		"type": "frida:agent",
+		"op":   "cancel",
+		"args": map[string]string{"id": req.id},
+	}, nil)
+}
+
+// agentCall runs op on the embedded agent and returns its JSON result,
+// or the binary data it replied with.
+func (sess *Session) agentCall(op string, args interface{}, data []byte) (result json.RawMessage, rdata []byte, err error) {
+	req, err := sess.agentStart(op, args, data)
+	if err != nil {
+		return
+	}
+	defer req.finish()
+
+	select {
+	case res := <-req.results:
+		return agentResult(op, res)
+	case <-req.scr.ctx.Done():
+		err = ErrInvalidOperation
+	case <-time.After(DefaultAgentTimeout):
+		err = fmt.Errorf("Agent: %s: %w", op, ErrTimedOut)
+	}
+	return
+}
+
+func agentResult(op string, res *rpcResult) (result json.RawMessage, data []byte, err error) {
+	var param interface{}
+	if len(res.params) > 0 {
+		param = res.params[0]
+	}
+	if res.operation != "ok" {
+		return nil, nil, fmt.Errorf("Agent: %s: %v", op, param)
+	}
+	result, err = json.Marshal(param)
+	return result, res.data, err
+}
+
+// formatAddress renders an address the way the agent's ptr() parses it,
+// without going through a lossy JSON number.
+func formatAddress(addr uint64) string {
+	return "0x" + strconv.FormatUint(addr, 16)
+}
+
+func parseAddress(s string) (uint64, error) {
+	return strconv.ParseUint(s, 0, 64)
+}
+
+// Address is a pointer in the target process. It travels as a hex string,
+// and null pointers decode to zero.
+type Address uint64
+
+func (a Address) String() string {
+	return formatAddress(uint64(a))
+}
+
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Address) UnmarshalJSON(b []byte) (err error) {
+	var s *string
+	if err = json.Unmarshal(b, &s); err != nil || s == nil {
+		*a = 0
+		return
+	}
+	v, err := parseAddress(*s)
+	*a = Address(v)
+	return
+}
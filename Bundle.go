@@ -0,0 +1,442 @@
+package fridago
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	reRequire       = regexp.MustCompile(`\brequire\s*\(\s*['"]([^'"]+)['"]\s*\)`)
+	reImportBare    = regexp.MustCompile(`(?m)^[ \t]*import\s+['"]([^'"]+)['"][ \t]*;?`)
+	reImportStar    = regexp.MustCompile(`(?m)^[ \t]*import\s+\*\s+as\s+([\w$]+)\s+from\s+['"]([^'"]+)['"][ \t]*;?`)
+	reImportFrom    = regexp.MustCompile(`(?m)^[ \t]*import\s+(?:([\w$]+)\s*,?\s*)?(?:\{([^}]*)\})?\s*from\s+['"]([^'"]+)['"][ \t]*;?`)
+	reExportStar    = regexp.MustCompile(`(?m)^[ \t]*export\s+\*\s+from\s+['"]([^'"]+)['"][ \t]*;?`)
+	reExportFrom    = regexp.MustCompile(`(?m)^[ \t]*export\s+\{([^}]*)\}\s*from\s+['"]([^'"]+)['"][ \t]*;?`)
+	reExportList    = regexp.MustCompile(`(?m)^[ \t]*export\s+\{([^}]*)\}[ \t]*;?`)
+	reExportDefault = regexp.MustCompile(`(?m)^([ \t]*)export\s+default\s+(?:((?:async\s+)?function\b\s*\*?\s*|class\s+)([\w$]+))?`)
+	reExportDecl    = regexp.MustCompile(`(?m)^([ \t]*)export\s+((?:async\s+)?function\s*\*?\s*|class\s+|const\s+|let\s+|var\s+)([\w$]+)`)
+	// whatever is left of these after toCommonJS is a form it cannot rewrite
+	reModuleSyntax = regexp.MustCompile(`(?m)^[ \t]*(?:export\b|import\b\s*[\w$*{'"])[^\n]*`)
+)
+
+const bundlePrelude = `(function () {
+function __importDefault(m) { return m && m.__esModule ? m.default : m; }
+var __cache = {};
+function __require(id) {
+  var cached = __cache[id];
+  if (cached !== undefined) return cached.exports;
+  var module = __cache[id] = { exports: {} };
+  var def = __modules[id];
+  def[0].call(module.exports, function (name) {
+    var dep = def[1][name];
+    if (dep === undefined) throw new Error("Cannot find module '" + name + "'");
+    return __require(dep);
+  }, module, module.exports);
+  return module.exports;
+}
+var __modules = {};
+`
+
+type BundleOptions struct {
+	// ModulesDir is searched for bare specifiers after the node_modules
+	// directories above the importing file.
+	ModulesDir string
+	// InlineSourceMap appends the source map to Source as a data URL.
+	InlineSourceMap bool
+}
+
+// Bundle is an agent and all its local dependencies, wrapped in a
+// CommonJS loader and ready for Session.CreateScriptSync.
+type Bundle struct {
+	Source    string
+	SourceMap []byte
+	// Files lists every file that went into the bundle, entry first.
+	Files []string
+}
+
+type bundleModule struct {
+	id       int
+	path     string
+	original string
+	source   string
+	deps     map[string]int
+}
+
+type bundler struct {
+	opts    BundleOptions
+	modules []*bundleModule
+	byPath  map[string]*bundleModule
+}
+
+// BundleFile bundles the agent at entry with the modules it imports.
+func BundleFile(entry string, opts *BundleOptions) (b *Bundle, err error) {
+	bd := &bundler{byPath: make(map[string]*bundleModule)}
+	if opts != nil {
+		bd.opts = *opts
+	}
+	entry, err = filepath.Abs(entry)
+	if err != nil {
+		return
+	}
+	if _, err = bd.add(entry); err != nil {
+		return
+	}
+	return bd.emit(entry), nil
+}
+
+func (bd *bundler) add(path string) (m *bundleModule, err error) {
+	if m = bd.byPath[path]; m != nil {
+		return
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	m = &bundleModule{
+		id:       len(bd.modules),
+		path:     path,
+		original: string(raw),
+		deps:     make(map[string]int),
+	}
+	bd.modules = append(bd.modules, m)
+	bd.byPath[path] = m
+
+	if strings.HasSuffix(path, ".json") {
+		m.source = "module.exports = " + strings.TrimSpace(string(raw)) + ";"
+		return
+	}
+	if m.source, err = toCommonJS(m.original); err != nil {
+		return nil, fmt.Errorf("Bundle: %s: %v", path, err)
+	}
+
+	// search code only, so requires in comments and strings are ignored
+	for _, idx := range reRequire.FindAllStringSubmatchIndex(maskNonCode(m.source), -1) {
+		spec := m.source[idx[2]:idx[3]]
+		if _, ok := m.deps[spec]; ok {
+			continue
+		}
+		resolved, ok := bd.resolve(filepath.Dir(path), spec)
+		if !ok {
+			return nil, fmt.Errorf("Bundle: cannot resolve '%s' from %s", spec, path)
+		}
+		dep, err := bd.add(resolved)
+		if err != nil {
+			return nil, err
+		}
+		m.deps[spec] = dep.id
+	}
+	return
+}
+
+func (bd *bundler) resolve(dir, spec string) (string, bool) {
+	if strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") || filepath.IsAbs(spec) {
+		p := spec
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, spec)
+		}
+		return resolvePath(p)
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if p, ok := resolvePath(filepath.Join(d, "node_modules", spec)); ok {
+			return p, true
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if bd.opts.ModulesDir != "" {
+		return resolvePath(filepath.Join(bd.opts.ModulesDir, spec))
+	}
+	return "", false
+}
+
+// resolvePath resolves p as a file, then as a package directory.
+func resolvePath(p string) (string, bool) {
+	if f, ok := resolveFile(p); ok {
+		return f, true
+	}
+	var pkg struct {
+		Main string `json:"main"`
+	}
+	if raw, err := os.ReadFile(filepath.Join(p, "package.json")); err == nil {
+		if json.Unmarshal(raw, &pkg) == nil && pkg.Main != "" {
+			if f, ok := resolveFile(filepath.Join(p, pkg.Main)); ok {
+				return f, true
+			}
+		}
+	}
+	return resolveFile(filepath.Join(p, "index.js"))
+}
+
+func resolveFile(p string) (string, bool) {
+	for _, candidate := range []string{p, p + ".js", p + ".json"} {
+		if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func (bd *bundler) emit(entry string) *Bundle {
+	var (
+		sb      strings.Builder
+		sm      sourceMapWriter
+		sources []string
+		content []string
+	)
+	base := filepath.Dir(entry)
+	sb.WriteString(bundlePrelude)
+	line := strings.Count(bundlePrelude, "\n")
+
+	b := &Bundle{}
+	for _, m := range bd.modules {
+		rel, err := filepath.Rel(base, m.path)
+		if err != nil {
+			rel = m.path
+		}
+		sources = append(sources, filepath.ToSlash(rel))
+		content = append(content, m.original)
+		b.Files = append(b.Files, m.path)
+
+		fmt.Fprintf(&sb, "__modules[%d] = [function (require, module, exports) {\n", m.id)
+		line++
+		for i, l := range strings.Split(m.source, "\n") {
+			sb.WriteString(l)
+			sb.WriteByte('\n')
+			sm.mapLine(line, m.id, i)
+			line++
+		}
+		deps, _ := json.Marshal(m.deps)
+		fmt.Fprintf(&sb, "}, %s];\n", deps)
+		line++
+	}
+	sb.WriteString("__require(0);\n})();\n")
+
+	b.SourceMap, _ = json.Marshal(map[string]interface{}{
+		"version":        3,
+		"file":           filepath.Base(entry),
+		"sources":        sources,
+		"sourcesContent": content,
+		"names":          []string{},
+		"mappings":       sm.String(),
+	})
+	b.Source = sb.String()
+	if bd.opts.InlineSourceMap {
+		b.Source += "//# sourceMappingURL=data:application/json;charset=utf-8;base64," +
+			base64.StdEncoding.EncodeToString(b.SourceMap) + "\n"
+	}
+	return b
+}
+
+// toCommonJS rewrites the common ES module forms into require/exports,
+// keeping every statement on its original line so line mappings hold. The
+// generated code is ES5, so it also runs on Duktape. Other module syntax,
+// such as destructuring exports, is reported as an error.
+func toCommonJS(src string) (string, error) {
+	var (
+		exported []string
+		esm      bool
+		imports  int
+	)
+	keepLines := func(match, repl string) string {
+		return repl + strings.Repeat("\n", strings.Count(match, "\n"))
+	}
+
+	src = replaceCode(src, reImportStar, func(sub []string) string {
+		return keepLines(sub[0], fmt.Sprintf("var %s = require(%q);", sub[1], sub[2]))
+	})
+	src = replaceCode(src, reImportFrom, func(sub []string) string {
+		if sub[1] == "" && sub[2] == "" {
+			return sub[0]
+		}
+		tmp := fmt.Sprintf("__import%d", imports)
+		imports++
+		stmts := []string{fmt.Sprintf("var %s = require(%q);", tmp, sub[3])}
+		if sub[2] != "" {
+			var vars []string
+			for _, pair := range splitList(sub[2]) {
+				vars = append(vars, fmt.Sprintf("%s = %s.%s", pair[1], tmp, pair[0]))
+			}
+			stmts = append(stmts, "var "+strings.Join(vars, ", ")+";")
+		}
+		if sub[1] != "" {
+			stmts = append(stmts, fmt.Sprintf("var %s = __importDefault(%s);", sub[1], tmp))
+		}
+		return keepLines(sub[0], strings.Join(stmts, " "))
+	})
+	src = replaceCode(src, reImportBare, func(sub []string) string {
+		return fmt.Sprintf("require(%q);", sub[1])
+	})
+	src = replaceCode(src, reExportStar, func(sub []string) string {
+		esm = true
+		return fmt.Sprintf("Object.assign(exports, require(%q));", sub[1])
+	})
+	src = replaceCode(src, reExportFrom, func(sub []string) string {
+		esm = true
+		var stmts []string
+		for _, pair := range splitList(sub[1]) {
+			stmts = append(stmts, fmt.Sprintf("exports.%s = __m.%s;", pair[1], pair[0]))
+		}
+		return keepLines(sub[0], fmt.Sprintf("(function (__m) { %s })(require(%q));", strings.Join(stmts, " "), sub[2]))
+	})
+	src = replaceCode(src, reExportList, func(sub []string) string {
+		for _, pair := range splitList(sub[1]) {
+			exported = append(exported, fmt.Sprintf("exports.%s = %s;", pair[1], pair[0]))
+		}
+		return keepLines(sub[0], "")
+	})
+	src = replaceCode(src, reExportDefault, func(sub []string) string {
+		esm = true
+		if sub[3] == "" || sub[3] == "extends" {
+			// anonymous, so an expression will do
+			return sub[1] + "exports.default = " + sub[2] + sub[3]
+		}
+		// keep the declaration, so its name stays in scope
+		exported = append(exported, fmt.Sprintf("exports.default = %s;", sub[3]))
+		return sub[1] + sub[2] + sub[3]
+	})
+	src = replaceCode(src, reExportDecl, func(sub []string) string {
+		exported = append(exported, fmt.Sprintf("exports.%s = %s;", sub[3], sub[3]))
+		return sub[1] + sub[2] + sub[3]
+	})
+
+	masked := maskNonCode(src)
+	if idx := reModuleSyntax.FindStringIndex(masked); idx != nil {
+		line := strings.Count(masked[:idx[0]], "\n") + 1
+		return "", fmt.Errorf("line %d: unsupported module syntax: %s", line, strings.TrimSpace(src[idx[0]:idx[1]]))
+	}
+
+	if esm || len(exported) > 0 {
+		src = `Object.defineProperty(exports, "__esModule", { value: true }); ` + src
+	}
+	if len(exported) > 0 {
+		src += "\n" + strings.Join(exported, " ")
+	}
+	return src, nil
+}
+
+// replaceCode is regexp.ReplaceAllStringFunc restricted to code: matches
+// are searched for in maskNonCode(src), so comments and the contents of
+// strings and templates are never rewritten, while fn gets the submatches
+// from src itself.
+func replaceCode(src string, re *regexp.Regexp, fn func(sub []string) string) string {
+	var (
+		sb   strings.Builder
+		last int
+	)
+	for _, idx := range re.FindAllStringSubmatchIndex(maskNonCode(src), -1) {
+		sub := make([]string, len(idx)/2)
+		for i := range sub {
+			if idx[2*i] >= 0 {
+				sub[i] = src[idx[2*i]:idx[2*i+1]]
+			}
+		}
+		sb.WriteString(src[last:idx[0]])
+		sb.WriteString(fn(sub))
+		last = idx[1]
+	}
+	sb.WriteString(src[last:])
+	return sb.String()
+}
+
+// splitList parses "a, b as c" into [[a a] [b c]].
+func splitList(list string) (pairs [][2]string) {
+	for _, item := range strings.Split(list, ",") {
+		fields := strings.Fields(item)
+		switch {
+		case len(fields) == 1:
+			pairs = append(pairs, [2]string{fields[0], fields[0]})
+		case len(fields) == 3 && fields[1] == "as":
+			pairs = append(pairs, [2]string{fields[0], fields[2]})
+		}
+	}
+	return
+}
+
+// maskNonCode blanks out comments and the contents of string literals,
+// keeping offsets and line breaks, so code patterns can be searched for
+// without false hits.
+func maskNonCode(src string) string {
+	b := []byte(src)
+	blank := func(i int) {
+		if b[i] != '\n' {
+			b[i] = ' '
+		}
+	}
+	for i := 0; i < len(b); {
+		switch {
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '/':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				blank(i)
+			}
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			for ; i < len(b) && !(b[i] == '*' && i+1 < len(b) && b[i+1] == '/'); i++ {
+				blank(i)
+			}
+			for end := i + 2; i < len(b) && i < end; i++ {
+				blank(i)
+			}
+		case b[i] == '\'' || b[i] == '"' || b[i] == '`':
+			quote := b[i]
+			for i++; i < len(b) && b[i] != quote; i++ {
+				if b[i] == '\\' && i+1 < len(b) {
+					blank(i)
+					i++
+				}
+				blank(i)
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	return string(b)
+}
+
+// sourceMapWriter encodes line-granular source map v3 mappings.
+type sourceMapWriter struct {
+	sb                         strings.Builder
+	genLine                    int
+	lastSrc, lastLine, lastCol int
+}
+
+func (w *sourceMapWriter) mapLine(genLine, src, origLine int) {
+	for w.genLine < genLine {
+		w.sb.WriteByte(';')
+		w.genLine++
+	}
+	writeVLQ(&w.sb, 0)
+	writeVLQ(&w.sb, src-w.lastSrc)
+	writeVLQ(&w.sb, origLine-w.lastLine)
+	writeVLQ(&w.sb, 0-w.lastCol)
+	w.lastSrc, w.lastLine, w.lastCol = src, origLine, 0
+}
+
+func (w *sourceMapWriter) String() string {
+	return w.sb.String()
+}
+
+const base64VLQ = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func writeVLQ(sb *strings.Builder, v int) {
+	u := v << 1
+	if v < 0 {
+		u = (-v << 1) | 1
+	}
+	for {
+		digit := u & 31
+		u >>= 5
+		if u > 0 {
+			digit |= 32
+		}
+		sb.WriteByte(base64VLQ[digit])
+		if u == 0 {
+			return
+		}
+	}
+}
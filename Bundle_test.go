@@ -0,0 +1,275 @@
+package fridago
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToCommonJS(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+		not  []string
+	}{
+		{
+			name: "import star",
+			src:  `import * as util from "./util";`,
+			want: []string{`var util = require("./util");`},
+		},
+		{
+			name: "import default and named",
+			src:  `import def, { a, b as c } from './lib';`,
+			want: []string{
+				`var __import0 = require("./lib");`,
+				`var a = __import0.a, c = __import0.b;`,
+				`var def = __importDefault(__import0);`,
+			},
+		},
+		{
+			name: "import bare",
+			src:  `import "./setup";`,
+			want: []string{`require("./setup");`},
+		},
+		{
+			name: "export star",
+			src:  `export * from "./a";`,
+			want: []string{`Object.assign(exports, require("./a"));`, `"__esModule"`},
+		},
+		{
+			name: "export from",
+			src:  `export { a, b as c } from "./a";`,
+			want: []string{`exports.a = __m.a; exports.c = __m.b;`},
+		},
+		{
+			name: "export list",
+			src:  "var a = 1, b = 2;\nexport { a, b as c };",
+			want: []string{"exports.a = a;", "exports.c = b;"},
+			not:  []string{"export {"},
+		},
+		{
+			name: "export declarations",
+			src:  "export function f() {}\nexport const x = 1;\nexport class K {}",
+			want: []string{"function f() {}", "const x = 1;", "class K {}", "exports.f = f;", "exports.x = x;", "exports.K = K;"},
+		},
+		{
+			name: "export default expression",
+			src:  `export default 42;`,
+			want: []string{"exports.default = 42;"},
+		},
+		{
+			name: "export default named function",
+			src:  "export default function greet() {}\ngreet();",
+			want: []string{"function greet() {}", "exports.default = greet;"},
+			not:  []string{"exports.default = function"},
+		},
+		{
+			name: "export default anonymous function",
+			src:  `export default function () {}`,
+			want: []string{"exports.default = function () {}"},
+		},
+		{
+			name: "export default anonymous class",
+			src:  `export default class extends Base {}`,
+			want: []string{"exports.default = class extends Base {}"},
+		},
+		{
+			name: "export default identifier starting with function",
+			src:  `export default functional;`,
+			want: []string{"exports.default = functional;"},
+		},
+		{
+			name: "template literal untouched",
+			src:  "var s = `\nexport default 1\nimport x from \"y\"\n`;",
+			want: []string{"export default 1", `import x from "y"`},
+			not:  []string{"exports.default", "require("},
+		},
+		{
+			name: "block comment untouched",
+			src:  "/*\nexport default 1\n*/",
+			want: []string{"export default 1"},
+			not:  []string{"exports.default"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toCommonJS(tt.src)
+			if err != nil {
+				t.Fatalf("toCommonJS: %v", err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("output lacks %q:\n%s", w, got)
+				}
+			}
+			for _, n := range tt.not {
+				if strings.Contains(got, n) {
+					t.Errorf("output contains %q:\n%s", n, got)
+				}
+			}
+			// line mappings rely on statements staying on their lines
+			if gl, sl := strings.Count(got, "\n"), strings.Count(tt.src, "\n"); gl < sl {
+				t.Errorf("output has %d line breaks, source %d", gl, sl)
+			}
+		})
+	}
+}
+
+func TestToCommonJSUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line string
+	}{
+		{"export destructuring", "var o = {};\nexport const { a, b } = o;", "line 2"},
+		{"export array destructuring", "export let [a] = [1];", "line 1"},
+		{"import default and star", `import d, * as ns from "./a";`, "line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := toCommonJS(tt.src)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.line) {
+				t.Errorf("error %q does not name %s", err, tt.line)
+			}
+		})
+	}
+}
+
+func TestMaskNonCode(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`a // b`, `a     `},
+		{"a /* b\nc */ d", "a     \n     d"},
+		{`x = "re\"q"`, `x = "     "`},
+		{`x = 'a' + b`, `x = ' ' + b`},
+		{"x = `a\nb`", "x = ` \n `"},
+		{`require("./a")`, `require("   ")`},
+	}
+	for _, tt := range tests {
+		if got := maskNonCode(tt.src); got != tt.want {
+			t.Errorf("maskNonCode(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" a, b as c ,d ")
+	want := [][2]string{{"a", "a"}, {"b", "c"}, {"d", "d"}}
+	if len(got) != len(want) {
+		t.Fatalf("splitList = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("splitList[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBundleResolve(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/a.js":                          "",
+		"src/data.json":                     "{}",
+		"src/lib/index.js":                  "",
+		"src/node_modules/pkg/package.json": `{"main": "main.js"}`,
+		"src/node_modules/pkg/main.js":      "",
+		"node_modules/up/index.js":          "",
+		"extra/ext.js":                      "",
+	})
+	bd := &bundler{opts: BundleOptions{ModulesDir: filepath.Join(dir, "extra")}}
+	src := filepath.Join(dir, "src")
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"./a", "src/a.js"},
+		{"./a.js", "src/a.js"},
+		{"./data", "src/data.json"},
+		{"./lib", "src/lib/index.js"},
+		{"pkg", "src/node_modules/pkg/main.js"},
+		{"up", "node_modules/up/index.js"},
+		{"ext", "extra/ext.js"},
+		{"./missing", ""},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		got, ok := bd.resolve(src, tt.spec)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("resolve(%q) = %q, want failure", tt.spec, got)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.want)); !ok || got != want {
+			t.Errorf("resolve(%q) = %q, %v, want %q", tt.spec, got, ok, want)
+		}
+	}
+}
+
+func TestBundleFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.js":  "import greet from './greet';\n// require('./nope')\ngreet();\n",
+		"greet.js": "export default function greet() {\n  send('hi');\n}\n",
+	})
+	b, err := BundleFile(filepath.Join(dir, "main.js"), &BundleOptions{InlineSourceMap: true})
+	if err != nil {
+		t.Fatalf("BundleFile: %v", err)
+	}
+	if len(b.Files) != 2 || filepath.Base(b.Files[0]) != "main.js" || filepath.Base(b.Files[1]) != "greet.js" {
+		t.Errorf("Files = %v", b.Files)
+	}
+	for _, w := range []string{`__modules[1]`, `{"./greet":1}`, "function greet() {", "exports.default = greet;", "sourceMappingURL=data:"} {
+		if !strings.Contains(b.Source, w) {
+			t.Errorf("bundle lacks %q", w)
+		}
+	}
+
+	sm, err := ParseSourceMap(b.SourceMap)
+	if err != nil {
+		t.Fatalf("ParseSourceMap: %v", err)
+	}
+	lines := strings.Split(b.Source, "\n")
+	for i, l := range lines {
+		if strings.Contains(l, "send('hi')") {
+			source, line, _, ok := sm.Lookup(i+1, 1)
+			if !ok || source != "greet.js" || line != 2 {
+				t.Errorf("Lookup(%d) = %q, %d, %v, want greet.js:2", i+1, source, line, ok)
+			}
+		}
+	}
+}
+
+func TestBundleFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"unresolved.js":  "require('./nope');\n",
+		"unsupported.js": "export const { a } = {};\n",
+	})
+	for _, name := range []string{"unresolved.js", "unsupported.js"} {
+		if _, err := BundleFile(filepath.Join(dir, name), nil); err == nil {
+			t.Errorf("BundleFile(%s) succeeded", name)
+		}
+	}
+}
@@ -55,9 +55,9 @@ func NewChild(fc *C.FridaChild) (c *Child, err error) {
 //export onChildAdded
 func onChildAdded(dev *C.FridaDevice, ptr *C.FridaChild, userData C.gpointer) {
 	log.Info("Device: On child added")
-	if chChildAdd != nil {
+	if d := lookupDevice(userData); d != nil {
 		if child, err := NewChild(ptr); err == nil {
-			*chChildAdd <- child
+			d.publish("child-added", child)
 		}
 	}
 }
@@ -1,13 +1,19 @@
 package fridago
 
 /*
+ #include <stdlib.h>
  #include "frida-core.h"
+ extern gulong _connect_signal(gpointer instance, const gchar * sig, GCallback cb, guint handle);
  extern void _on_spawn_added(FridaDevice * device, FridaSpawn * spawn, gpointer user_data);
  extern void _on_child_added(FridaDevice * device, FridaChild * child, gpointer user_data);
  extern void _on_output(FridaDevice * device, guint pid, gint fd, GBytes * data, gpointer user_data);
 */
 import "C"
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/sirupsen/logrus"
@@ -20,9 +26,8 @@ const (
 )
 
 var (
-	chChildAdd *chan *Child
-	chSpawnAdd *chan *Spawn
-	chOutput   *chan *Output
+	devices     sync.Map
+	deviceIDNum uint64 = 0
 )
 
 type Output struct {
@@ -37,6 +42,66 @@ type Device struct {
 	ID   string
 	Type uint
 	// Icon *Icon
+
+	handle uint
+	mu     sync.Mutex
+	subs   map[string][]*deviceSub
+}
+
+// deviceSub feeds a subscriber's channel from its own goroutine, so that a
+// slow subscriber never blocks the frida main loop or other subscribers.
+type deviceSub struct {
+	ch    interface{}
+	queue *eventQueue[interface{}]
+	stop  chan struct{}
+}
+
+func newDeviceSub(ch interface{}) *deviceSub {
+	s := &deviceSub{
+		ch:    ch,
+		queue: newEventQueue[interface{}](),
+		stop:  make(chan struct{}),
+	}
+	go s.forward()
+	return s
+}
+
+func (s *deviceSub) forward() {
+	for {
+		item, ok := s.queue.pop()
+		if !ok {
+			return
+		}
+		var sent bool
+		switch ch := s.ch.(type) {
+		case chan *Child:
+			select {
+			case ch <- item.(*Child):
+				sent = true
+			case <-s.stop:
+			}
+		case chan *Spawn:
+			select {
+			case ch <- item.(*Spawn):
+				sent = true
+			case <-s.stop:
+			}
+		case chan *Output:
+			select {
+			case ch <- item.(*Output):
+				sent = true
+			case <-s.stop:
+			}
+		}
+		if !sent {
+			return
+		}
+	}
+}
+
+func (s *deviceSub) close() {
+	close(s.stop)
+	s.queue.close()
 }
 
 func (d *Device) IsLost() bool {
@@ -122,6 +187,8 @@ func (d *Device) EnumerateProcessesSync() (pl []*Process, err error) {
 	return
 }
 
+// FindProcessByPidSync returns the process with the given pid. It never
+// waits; a pid that is not running is an error.
 func (d *Device) FindProcessByPidSync(pid uint) (p *Process, err error) {
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
@@ -152,6 +219,141 @@ func (d *Device) FindProcessByNameSync(name string, timeout int) (p *Process, er
 	return NewProcess(proc)
 }
 
+// FindProcesses returns the running processes accepted by match.
+func (d *Device) FindProcesses(match ProcessMatcher) (pl []*Process, err error) {
+	processes, err := d.EnumerateProcessesSync()
+	if err != nil {
+		return
+	}
+	for _, p := range processes {
+		if match(p) {
+			pl = append(pl, p)
+		}
+	}
+	return
+}
+
+// WaitForProcess polls the device until a process accepted by match is
+// running, and returns the first one found.
+func (d *Device) WaitForProcess(ctx context.Context, match ProcessMatcher) (p *Process, err error) {
+	ticker := time.NewTicker(ProcessPollInterval)
+	defer ticker.Stop()
+	for {
+		pl, err := d.FindProcesses(match)
+		if err != nil {
+			return nil, err
+		}
+		if len(pl) > 0 {
+			return pl[0], nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WatchProcesses enumerates processes every interval and reports the
+// differences as events, starting with the initial snapshot. The channel
+// is closed when ctx is done or enumeration fails. A non-positive interval
+// means ProcessPollInterval.
+func (d *Device) WatchProcesses(ctx context.Context, interval time.Duration) <-chan ProcessEvent {
+	return d.watchProcesses(ctx, interval, nil)
+}
+
+// watchProcesses is WatchProcesses that also hands the enumeration error,
+// if any, to failed before closing the channel.
+func (d *Device) watchProcesses(ctx context.Context, interval time.Duration, failed func(error)) <-chan ProcessEvent {
+	if interval <= 0 {
+		interval = ProcessPollInterval
+	}
+	ch := make(chan ProcessEvent)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var known map[uint]*Process
+		for {
+			pl, err := d.EnumerateProcessesSync()
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"device": d.ID,
+					"err":    err,
+				}).Error("Device: watch processes failed")
+				if failed != nil {
+					failed(err)
+				}
+				return
+			}
+			initial := known == nil
+			next, events := diffProcesses(known, pl)
+			known = next
+			for _, evt := range events {
+				evt.Initial = initial
+				select {
+				case ch <- evt:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch
+}
+
+// GetProcessByPid returns the process with the given pid, failing with a
+// GError if there is none.
+func (d *Device) GetProcessByPid(pid uint) (p *Process, err error) {
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	proc := C.frida_device_get_process_by_pid_sync(d.ptr, C.guint(pid), cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+		return
+	}
+	return NewProcess(proc)
+}
+
+// GetProcessByName waits up to timeout milliseconds for a process named
+// name, failing with a GError if none appears.
+func (d *Device) GetProcessByName(name string, timeout int) (p *Process, err error) {
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	proc := C.frida_device_get_process_by_name_sync(d.ptr, cName, C.gint(timeout), cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+		return
+	}
+	return NewProcess(proc)
+}
+
+// FindProcessByName returns the process named name, or nil if it is not
+// running, without waiting for it to start.
+func (d *Device) FindProcessByName(name string) (p *Process, err error) {
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	proc := C.frida_device_find_process_by_name_sync(d.ptr, cName, 0, cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+		return
+	}
+	if IsNullCPointer(unsafe.Pointer(proc)) {
+		return
+	}
+	return NewProcess(proc)
+}
+
 func (d *Device) EnumerateApplicationsSync() (al []*Application, err error) {
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
@@ -220,38 +422,82 @@ func (d *Device) DisableSpawnGating() (err error) {
 	return
 }
 
-func (d *Device) connectSignal(sig string, cb unsafe.Pointer) {
-	C.g_signal_connect_data(C.gpointer(d.ptr),
-		C.CString(sig), C.GCallback(cb),
-		nil, nil, 0)
-}
-
+// On subscribes ch to a device signal. Every subscriber of a signal gets
+// each event, queued so a slow reader holds up no one else; the signal is
+// connected on the first subscription.
 func (d *Device) On(sig string, ch interface{}) (err error) {
+	var (
+		cb unsafe.Pointer
+		ok bool
+	)
 	switch sig {
 	case "child-added":
-		if v, ok := ch.(chan *Child); ok {
-			chChildAdd = &v
-		}
-		d.connectSignal(sig, unsafe.Pointer(C._on_child_added))
+		_, ok = ch.(chan *Child)
+		cb = unsafe.Pointer(C._on_child_added)
 	case "spawn-added":
-		if v, ok := ch.(chan *Spawn); ok {
-			chSpawnAdd = &v
-		}
-		d.connectSignal(sig, unsafe.Pointer(C._on_spawn_added))
+		_, ok = ch.(chan *Spawn)
+		cb = unsafe.Pointer(C._on_spawn_added)
 	case "output":
-		if v, ok := ch.(chan *Output); ok {
-			chOutput = &v
-		}
-		d.connectSignal(sig, unsafe.Pointer(C._on_output))
+		_, ok = ch.(chan *Output)
+		cb = unsafe.Pointer(C._on_output)
 	default:
 		err = NewErrorAndLog("Device: signal unspported")
 		log.WithFields(logrus.Fields{
 			"signal": sig,
 		}).Error(err)
+		return
 	}
+	if !ok {
+		return NewErrorAndLog("Device: wrong channel type for signal " + sig)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subs == nil {
+		d.subs = make(map[string][]*deviceSub)
+	}
+	if _, connected := d.subs[sig]; !connected {
+		devices.Store(d.handle, d)
+		cSig := C.CString(sig)
+		defer C.free(unsafe.Pointer(cSig))
+		C._connect_signal(C.gpointer(d.ptr), cSig, C.GCallback(cb), C.guint(d.handle))
+	}
+	d.subs[sig] = append(d.subs[sig], newDeviceSub(ch))
 	return
 }
 
+// Off removes a channel subscribed with On. Events not yet delivered to it
+// are dropped.
+func (d *Device) Off(sig string, ch interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	subs := d.subs[sig]
+	for i, sub := range subs {
+		if sub.ch == ch {
+			d.subs[sig] = append(subs[:i:i], subs[i+1:]...)
+			sub.close()
+			return
+		}
+	}
+}
+
+// publish queues item for every subscriber of sig; it never blocks.
+func (d *Device) publish(sig string, item interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, sub := range d.subs[sig] {
+		sub.queue.push(item)
+	}
+}
+
+func lookupDevice(userData C.gpointer) *Device {
+	v, ok := devices.Load(uint(uintptr(userData)))
+	if !ok {
+		return nil
+	}
+	return v.(*Device)
+}
+
 func (d *Device) EnumeratePendingSpawnSync() (sl []*Spawn, err error) {
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
@@ -328,14 +574,17 @@ func (d *Device) fromFridaDevice() (err error) {
 // open_channel
 
 func NewDevice(fd *C.FridaDevice) (d *Device, err error) {
-	d = &Device{ptr: fd}
+	d = &Device{
+		ptr:    fd,
+		handle: uint(atomic.AddUint64(&deviceIDNum, 1)),
+	}
 	err = d.fromFridaDevice()
 	return
 }
 
 //export onOutput
 func onOutput(device *C.FridaDevice, pid C.guint, fd C.gint, data *C.GBytes, userData C.gpointer) {
-	if chOutput != nil {
+	if d := lookupDevice(userData); d != nil {
 		o := &Output{
 			Pid: uint(pid),
 			Fd:  int(fd),
@@ -345,6 +594,6 @@ func onOutput(device *C.FridaDevice, pid C.guint, fd C.gint, data *C.GBytes, use
 			dBuf := C.g_bytes_get_data(data, &dSize)
 			o.Data = C.GoBytes(unsafe.Pointer(dBuf), C.int(dSize))
 		}
-		*chOutput <- o
+		d.publish("output", o)
 	}
 }
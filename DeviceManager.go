@@ -1,24 +1,75 @@
 package fridago
 
 /*
+ #include <stdlib.h>
  #include "frida-core.h"
+ extern gulong _connect_signal(gpointer instance, const gchar * sig, GCallback cb, guint handle);
+ extern gpointer _ref_object(gpointer obj);
+ extern void _on_device_added(FridaDeviceManager * manager, FridaDevice * device, gpointer user_data);
+ extern void _on_device_removed(FridaDeviceManager * manager, FridaDevice * device, gpointer user_data);
+ extern void _on_devices_changed(FridaDeviceManager * manager, gpointer user_data);
 */
 import "C"
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	deviceManagers     sync.Map
+	deviceManagerIDNum uint64 = 0
+)
+
+type DeviceEventType int
+
+const (
+	DeviceAdded DeviceEventType = iota
+	DeviceRemoved
+	DeviceChanged
+)
+
+func (t DeviceEventType) String() string {
+	switch t {
+	case DeviceAdded:
+		return "added"
+	case DeviceRemoved:
+		return "removed"
+	case DeviceChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// DeviceEvent reports a device coming or going. Device is nil for
+// DeviceChanged, and Initial marks the events of the starting snapshot.
+type DeviceEvent struct {
+	Type    DeviceEventType
+	Device  *Device
+	Initial bool
+}
+
 type DeviceManager struct {
-	ptr *C.FridaDeviceManager
+	ptr    *C.FridaDeviceManager
+	handle uint
+	// life keeps ptr valid: calls into frida hold it shared, Close holds it
+	// exclusively.
+	life sync.RWMutex
+
+	mu         sync.Mutex
+	handlerIDs []C.gulong
+	watchers   map[*eventQueue[DeviceEvent]]struct{}
 }
 
 func (dm *DeviceManager) init() (err error) {
 	log.Info("DeviceManager: new ...")
-	manager, err := C.frida_device_manager_new()
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"err": err,
-		}).Error("DeviceManager: new fail")
+	manager := C.frida_device_manager_new()
+	if IsNullCPointer(unsafe.Pointer(manager)) {
+		err = NewErrorAndLog("DeviceManager: new fail")
 	} else {
 		log.Info("DeviceManager: new ok")
 		dm.ptr = manager
@@ -26,8 +77,24 @@ func (dm *DeviceManager) init() (err error) {
 	return
 }
 
+// acquire pins the manager open for a call into frida; release it with
+// dm.life.RUnlock. It fails with ErrInvalidOperation once closed.
+func (dm *DeviceManager) acquire() (ptr *C.FridaDeviceManager, err error) {
+	dm.life.RLock()
+	if dm.ptr == nil {
+		dm.life.RUnlock()
+		return nil, ErrInvalidOperation
+	}
+	return dm.ptr, nil
+}
+
 func (dm *DeviceManager) Close() (err error) {
 	log.Info("DeviceManager: Close")
+	dm.life.Lock()
+	defer dm.life.Unlock()
+	if dm.ptr == nil {
+		return ErrInvalidOperation
+	}
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
 	C.frida_device_manager_close_sync(dm.ptr, cancel, &gerr)
@@ -36,15 +103,33 @@ func (dm *DeviceManager) Close() (err error) {
 		return
 	}
 
+	dm.mu.Lock()
+	for _, id := range dm.handlerIDs {
+		C.g_signal_handler_disconnect(C.gpointer(dm.ptr), id)
+	}
+	dm.handlerIDs = nil
+	for q := range dm.watchers {
+		q.close()
+	}
+	dm.watchers = nil
 	C.frida_unref(C.gpointer(dm.ptr))
 	dm.ptr = nil
+	dm.mu.Unlock()
+	deviceManagers.Delete(dm.handle)
+	releaseDeviceManager(dm)
 	return
 }
 
 func (dm *DeviceManager) EnumerateDevicesSync() (dl []*Device, err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
-	devices := C.frida_device_manager_enumerate_devices_sync(dm.ptr, cancel, &gerr)
+	devices := C.frida_device_manager_enumerate_devices_sync(ptr, cancel, &gerr)
 	if gerr != nil {
 		err = NewErrorFromGError(gerr)
 		return
@@ -70,9 +155,15 @@ func (dm *DeviceManager) EnumerateDevicesSync() (dl []*Device, err error) {
 }
 
 func (dm *DeviceManager) GetDeviceById(id string, timeout int) (d *Device, err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
-	dev := C.frida_device_manager_get_device_by_id_sync(dm.ptr, C.CString(id), C.gint(timeout), cancel, &gerr)
+	dev := C.frida_device_manager_get_device_by_id_sync(ptr, C.CString(id), C.gint(timeout), cancel, &gerr)
 	if gerr != nil {
 		err = NewErrorFromGError(gerr)
 		return
@@ -81,9 +172,15 @@ func (dm *DeviceManager) GetDeviceById(id string, timeout int) (d *Device, err e
 }
 
 func (dm *DeviceManager) GetDeviceByType(dtype C.FridaDeviceType, timeout int) (d *Device, err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
-	dev := C.frida_device_manager_get_device_by_type_sync(dm.ptr, dtype, C.gint(timeout), cancel, &gerr)
+	dev := C.frida_device_manager_get_device_by_type_sync(ptr, dtype, C.gint(timeout), cancel, &gerr)
 	if gerr != nil {
 		err = NewErrorFromGError(gerr)
 		return
@@ -91,12 +188,266 @@ func (dm *DeviceManager) GetDeviceByType(dtype C.FridaDeviceType, timeout int) (
 	return NewDevice(dev)
 }
 
-// todo:
-// add_remote_device
-// remove_remote_device
+// FindDeviceById returns the device with the given id, or nil if it is not
+// currently known, without waiting for it to appear.
+func (dm *DeviceManager) FindDeviceById(id string) (d *Device, err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	cID := C.CString(id)
+	defer C.free(unsafe.Pointer(cID))
+	dev := C.frida_device_manager_find_device_by_id_sync(ptr, cID, 0, cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+		return
+	}
+	if IsNullCPointer(unsafe.Pointer(dev)) {
+		return
+	}
+	return NewDevice(dev)
+}
+
+// FindDeviceByType returns the first device of dtype (one of the DeviceType
+// constants), or nil if there is none, without waiting for one to appear.
+func (dm *DeviceManager) FindDeviceByType(dtype uint) (d *Device, err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	dev := C.frida_device_manager_find_device_by_type_sync(ptr, C.FridaDeviceType(dtype), 0, cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+		return
+	}
+	if IsNullCPointer(unsafe.Pointer(dev)) {
+		return
+	}
+	return NewDevice(dev)
+}
+
+// GetDeviceMatching waits up to timeout for a device satisfying predicate
+// and returns ErrTimedOut if none shows up. A zero timeout only checks the
+// current devices; a negative one waits forever.
+func (dm *DeviceManager) GetDeviceMatching(predicate func(*Device) bool, timeout time.Duration) (d *Device, err error) {
+	if timeout == 0 {
+		devices, err := dm.EnumerateDevicesSync()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			if predicate(d) {
+				return d, nil
+			}
+		}
+		return nil, ErrTimedOut
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	for evt := range dm.Watch(ctx) {
+		if evt.Type == DeviceAdded && predicate(evt.Device) {
+			return evt.Device, nil
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, ErrTimedOut
+	}
+	return nil, ErrInvalidOperation
+}
+
+// AddRemoteDevice connects to a frida-server listening on host, given as
+// "address" or "address:port".
+func (dm *DeviceManager) AddRemoteDevice(host string) (d *Device, err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	cHost := C.CString(host)
+	defer C.free(unsafe.Pointer(cHost))
+	dev := C.frida_device_manager_add_remote_device_sync(ptr, cHost, cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+		return
+	}
+	if IsNullCPointer(unsafe.Pointer(dev)) {
+		err = NewErrorAndLog("DeviceManager: add remote device failed")
+		return
+	}
+	d, err = NewDevice(dev)
+	log.WithFields(logrus.Fields{
+		"host": host,
+		"id":   d.ID,
+	}).Debug("DeviceManager: add remote device")
+	return
+}
+
+func (dm *DeviceManager) RemoveRemoteDevice(host string) (err error) {
+	ptr, err := dm.acquire()
+	if err != nil {
+		return
+	}
+	defer dm.life.RUnlock()
+
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	cHost := C.CString(host)
+	defer C.free(unsafe.Pointer(cHost))
+	C.frida_device_manager_remove_remote_device_sync(ptr, cHost, cancel, &gerr)
+	if gerr != nil {
+		err = NewErrorFromGError(gerr)
+	}
+	return
+}
+
+// Watch reports the current devices, then every device added or removed,
+// until ctx is done or the manager is closed. A device added while the
+// snapshot is taken may be reported twice.
+func (dm *DeviceManager) Watch(ctx context.Context) <-chan DeviceEvent {
+	ch := make(chan DeviceEvent)
+	q := newEventQueue[DeviceEvent]()
+
+	ptr, err := dm.acquire()
+	if err != nil {
+		close(ch)
+		return ch
+	}
+	dm.mu.Lock()
+	if dm.handlerIDs == nil {
+		dm.connectSignals(ptr)
+	}
+	if dm.watchers == nil {
+		dm.watchers = make(map[*eventQueue[DeviceEvent]]struct{})
+	}
+	dm.watchers[q] = struct{}{}
+	dm.mu.Unlock()
+	dm.life.RUnlock()
+
+	devices, err := dm.EnumerateDevicesSync()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("DeviceManager: watch snapshot failed")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		dm.mu.Lock()
+		delete(dm.watchers, q)
+		dm.mu.Unlock()
+		q.close()
+	}()
+	go func() {
+		defer close(ch)
+		defer close(stopped)
+		for _, d := range devices {
+			select {
+			case ch <- DeviceEvent{Type: DeviceAdded, Device: d, Initial: true}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			evt, ok := q.pop()
+			if !ok {
+				return
+			}
+			select {
+			case ch <- evt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (dm *DeviceManager) connectSignals(ptr *C.FridaDeviceManager) {
+	for sig, cb := range map[string]unsafe.Pointer{
+		"added":   unsafe.Pointer(C._on_device_added),
+		"removed": unsafe.Pointer(C._on_device_removed),
+		"changed": unsafe.Pointer(C._on_devices_changed),
+	} {
+		cSig := C.CString(sig)
+		id := C._connect_signal(C.gpointer(ptr), cSig, C.GCallback(cb), C.guint(dm.handle))
+		C.free(unsafe.Pointer(cSig))
+		dm.handlerIDs = append(dm.handlerIDs, id)
+	}
+}
+
+func (dm *DeviceManager) broadcast(evt DeviceEvent) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	for q := range dm.watchers {
+		q.push(evt)
+	}
+}
+
+func lookupDeviceManager(userData C.gpointer) *DeviceManager {
+	v, ok := deviceManagers.Load(uint(uintptr(userData)))
+	if !ok {
+		return nil
+	}
+	return v.(*DeviceManager)
+}
+
+//export onDeviceAdded
+func onDeviceAdded(manager *C.FridaDeviceManager, device *C.FridaDevice, userData C.gpointer) {
+	if dm := lookupDeviceManager(userData); dm != nil {
+		// the signal only lends us the device; own it like EnumerateDevicesSync
+		C._ref_object(C.gpointer(device))
+		d, _ := NewDevice(device)
+		dm.broadcast(DeviceEvent{Type: DeviceAdded, Device: d})
+	}
+}
+
+//export onDeviceRemoved
+func onDeviceRemoved(manager *C.FridaDeviceManager, device *C.FridaDevice, userData C.gpointer) {
+	if dm := lookupDeviceManager(userData); dm != nil {
+		// the signal only lends us the device; own it like EnumerateDevicesSync
+		C._ref_object(C.gpointer(device))
+		d, _ := NewDevice(device)
+		dm.broadcast(DeviceEvent{Type: DeviceRemoved, Device: d})
+	}
+}
+
+//export onDevicesChanged
+func onDevicesChanged(manager *C.FridaDeviceManager, userData C.gpointer) {
+	if dm := lookupDeviceManager(userData); dm != nil {
+		dm.broadcast(DeviceEvent{Type: DeviceChanged})
+	}
+}
 
 func NewDeviceManager() (dm *DeviceManager, err error) {
-	dm = new(DeviceManager)
-	err = dm.init()
+	dm = &DeviceManager{
+		handle: uint(atomic.AddUint64(&deviceManagerIDNum, 1)),
+	}
+	if err = dm.init(); err == nil {
+		deviceManagers.Store(dm.handle, dm)
+	}
 	return
 }
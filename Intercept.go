@@ -0,0 +1,250 @@
+package fridago
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type argKind int
+
+const (
+	argInt argKind = iota
+	argPointer
+	argCString
+	argBuffer
+)
+
+// ArgType tells Intercept how to marshal an argument to Go.
+type ArgType struct {
+	kind      argKind
+	lengthArg int
+}
+
+var (
+	ArgInt     = ArgType{kind: argInt}
+	ArgPointer = ArgType{kind: argPointer}
+	ArgCString = ArgType{kind: argCString}
+)
+
+// ArgBuffer is a pointer to a buffer whose size is the integer argument at
+// index lengthArg.
+func ArgBuffer(lengthArg int) ArgType {
+	return ArgType{kind: argBuffer, lengthArg: lengthArg}
+}
+
+// SymbolOrAddress is a hook target: a symbol name as accepted by
+// ResolveSymbol, or an Address.
+type SymbolOrAddress interface{}
+
+// HookSpec describes the arguments of a hooked function and the handlers
+// called around it. OnLeave runs while the calling thread waits for it,
+// so it can replace the return value.
+type HookSpec struct {
+	Args    []ArgType
+	OnEnter func(c *Call)
+	OnLeave func(c *Call)
+}
+
+// Call is one invocation of a hooked function. Args hold an int64, an
+// Address, a string or a []byte per ArgType; null strings and buffers are
+// nil. Buffers are read again on leave, to see what the function wrote.
+type Call struct {
+	ID          uint64
+	ThreadID    int
+	Args        []interface{}
+	ReturnValue Address
+
+	replace *Address
+}
+
+// ReplaceReturn makes the hooked function return v; only effective in
+// OnLeave.
+func (c *Call) ReplaceReturn(v int64) {
+	a := Address(uint64(v))
+	c.replace = &a
+}
+
+// Hook is a function intercepted with Session.Intercept.
+type Hook struct {
+	Target Address
+	spec   HookSpec
+	script *Script
+}
+
+func (h *Hook) Detach() error {
+	return h.script.UnLoad()
+}
+
+func (sess *Session) resolveTarget(target SymbolOrAddress) (addr Address, err error) {
+	switch t := target.(type) {
+	case string:
+		return sess.ResolveSymbol(t)
+	case Address:
+		return t, nil
+	case uint64:
+		return Address(t), nil
+	case uintptr:
+		return Address(t), nil
+	}
+	return 0, ErrInvalidArgument
+}
+
+// Intercept hooks the function at target with an agent generated from
+// spec.
+func (sess *Session) Intercept(target SymbolOrAddress, spec HookSpec) (h *Hook, err error) {
+	for _, a := range spec.Args {
+		if a.kind == argBuffer && (a.lengthArg < 0 || a.lengthArg >= len(spec.Args) || spec.Args[a.lengthArg].kind != argInt) {
+			return nil, NewErrorAndLog("Intercept: buffer length must be an ArgInt argument")
+		}
+	}
+	addr, err := sess.resolveTarget(target)
+	if err != nil {
+		return
+	}
+
+	scr, err := sess.CreateScriptSync("fridago-hook-"+addr.String(), hookSource(addr, &spec))
+	if err != nil {
+		return
+	}
+	if scr == nil {
+		return nil, NewErrorAndLog("Intercept: create script failed")
+	}
+	h = &Hook{Target: addr, spec: spec, script: scr}
+	scr.OnMessage(h.onMessage)
+	if err = scr.Load(); err != nil {
+		scr.UnLoad()
+		return nil, err
+	}
+	return
+}
+
+// hookSource generates the Interceptor agent for spec. Arguments are
+// marshalled to a list of values, with buffers appended to the message
+// data and replaced by their size.
+func hookSource(addr Address, spec *HookSpec) string {
+	var b strings.Builder
+	b.WriteString("(function () {\n")
+	b.WriteString("  var nextCall = 1;\n")
+	b.WriteString("  function marshal(args) {\n")
+	b.WriteString("    var values = [], chunks = [], size = 0, buf;\n")
+	for i, a := range spec.Args {
+		switch a.kind {
+		case argInt, argPointer:
+			fmt.Fprintf(&b, "    values.push(args[%d].toString());\n", i)
+		case argCString:
+			fmt.Fprintf(&b, "    values.push(args[%d].isNull() ? null : args[%d].readUtf8String());\n", i, i)
+		case argBuffer:
+			fmt.Fprintf(&b, "    buf = args[%d].isNull() ? null : args[%d].readByteArray(args[%d].toUInt32());\n", i, i, a.lengthArg)
+			b.WriteString("    values.push(buf === null ? null : buf.byteLength);\n")
+			b.WriteString("    if (buf !== null) { chunks.push(buf); size += buf.byteLength; }\n")
+		}
+	}
+	b.WriteString("    var data = new Uint8Array(size), off = 0;\n")
+	b.WriteString("    chunks.forEach(function (c) { data.set(new Uint8Array(c), off); off += c.byteLength; });\n")
+	b.WriteString("    return { values: values, data: data.buffer };\n")
+	b.WriteString("  }\n")
+
+	fmt.Fprintf(&b, "  Interceptor.attach(ptr('%s'), {\n", addr)
+	b.WriteString("    onEnter: function (args) {\n")
+	b.WriteString("      this.call = nextCall++;\n")
+	fmt.Fprintf(&b, "      this.args = [];\n      for (var i = 0; i < %d; i++) this.args.push(args[i]);\n", len(spec.Args))
+	if spec.OnEnter != nil {
+		b.WriteString("      var m = marshal(this.args);\n")
+		b.WriteString("      send(['frida:hook', 'enter', this.call, this.threadId, m.values, null], m.data);\n")
+	}
+	b.WriteString("    }")
+	if spec.OnLeave != nil {
+		b.WriteString(",\n    onLeave: function (retval) {\n")
+		b.WriteString("      var m = marshal(this.args), reply = null;\n")
+		b.WriteString("      send(['frida:hook', 'leave', this.call, this.threadId, m.values, retval.toString()], m.data);\n")
+		b.WriteString("      recv('frida:hook:' + this.call, function (message) { reply = message; }).wait();\n")
+		b.WriteString("      if (reply.retval !== null) retval.replace(ptr(reply.retval));\n")
+		b.WriteString("    }")
+	}
+	b.WriteString("\n  });\n})();\n")
+	return b.String()
+}
+
+func (h *Hook) onMessage(msg Message) {
+	var payload []json.RawMessage
+	var tag, phase string
+	if json.Unmarshal(msg.Payload, &payload) != nil || len(payload) < 6 ||
+		json.Unmarshal(payload[0], &tag) != nil || tag != "frida:hook" {
+		return
+	}
+	json.Unmarshal(payload[1], &phase)
+
+	c := new(Call)
+	json.Unmarshal(payload[2], &c.ID)
+	json.Unmarshal(payload[3], &c.ThreadID)
+	json.Unmarshal(payload[5], &c.ReturnValue)
+	var values []json.RawMessage
+	json.Unmarshal(payload[4], &values)
+	c.Args = h.unmarshalArgs(values, msg.Data)
+
+	switch phase {
+	case "enter":
+		if h.spec.OnEnter != nil {
+			h.spec.OnEnter(c)
+		}
+	case "leave":
+		// the target thread is blocked until this reply, whatever happens
+		defer func() {
+			reply := map[string]interface{}{
+				"type":   fmt.Sprintf("frida:hook:%d", c.ID),
+				"retval": nil,
+			}
+			if c.replace != nil {
+				reply["retval"] = c.replace.String()
+			}
+			if err := h.script.PostJSON(reply, nil); err != nil {
+				log.WithFields(logrus.Fields{
+					"target": h.Target.String(),
+					"err":    err,
+				}).Error("Intercept: reply failed")
+			}
+		}()
+		if h.spec.OnLeave != nil {
+			h.spec.OnLeave(c)
+		}
+	}
+}
+
+func (h *Hook) unmarshalArgs(values []json.RawMessage, data []byte) (args []interface{}) {
+	for i, a := range h.spec.Args {
+		if i >= len(values) {
+			break
+		}
+		switch a.kind {
+		case argInt:
+			var v Address
+			json.Unmarshal(values[i], &v)
+			args = append(args, int64(v))
+		case argPointer:
+			var v Address
+			json.Unmarshal(values[i], &v)
+			args = append(args, v)
+		case argCString:
+			var s *string
+			json.Unmarshal(values[i], &s)
+			if s == nil {
+				args = append(args, nil)
+			} else {
+				args = append(args, *s)
+			}
+		case argBuffer:
+			var size *int
+			json.Unmarshal(values[i], &size)
+			if size == nil || *size > len(data) {
+				args = append(args, nil)
+				continue
+			}
+			args = append(args, data[:*size])
+			data = data[*size:]
+		}
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package fridago
+
+import (
+	"encoding/json"
+)
+
+// memoryChunkSize caps the bytes moved by a single agent request.
+const memoryChunkSize = 1024 * 1024
+
+// Memory accesses the address space of a session's process through the
+// embedded agent. Offsets passed to ReadAt and WriteAt are addresses.
+type Memory struct {
+	sess *Session
+}
+
+func (sess *Session) Memory() *Memory {
+	return &Memory{sess: sess}
+}
+
+// ReadAt reads len(p) bytes at address off. On a fault it returns the
+// bytes read up to the failing chunk along with the error.
+func (m *Memory) ReadAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		size := len(p) - n
+		if size > memoryChunkSize {
+			size = memoryChunkSize
+		}
+		var data []byte
+		_, data, err = m.sess.agentCall("memory:read", map[string]interface{}{
+			"address": formatAddress(uint64(off) + uint64(n)),
+			"size":    size,
+		}, nil)
+		if err != nil {
+			return
+		}
+		if len(data) < size {
+			n += copy(p[n:], data)
+			return n, ErrInvalidOperation
+		}
+		n += copy(p[n:], data)
+	}
+	return
+}
+
+// WriteAt writes p at address off. The pages must be writable; see Protect.
+func (m *Memory) WriteAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		size := len(p) - n
+		if size > memoryChunkSize {
+			size = memoryChunkSize
+		}
+		_, _, err = m.sess.agentCall("memory:write", map[string]interface{}{
+			"address": formatAddress(uint64(off) + uint64(n)),
+		}, p[n:n+size])
+		if err != nil {
+			return
+		}
+		n += size
+	}
+	return
+}
+
+// Protect changes the protection of the pages spanning [addr, addr+size),
+// with protection given as "rwx", "r--" and so on.
+func (m *Memory) Protect(addr uint64, size int, protection string) (err error) {
+	_, _, err = m.sess.agentCall("memory:protect", map[string]interface{}{
+		"address":    formatAddress(addr),
+		"size":       size,
+		"protection": protection,
+	}, nil)
+	return
+}
+
+// Alloc allocates size bytes in the target. The memory lives until Free
+// or until the session ends.
+func (m *Memory) Alloc(size int) (addr uint64, err error) {
+	result, _, err := m.sess.agentCall("memory:alloc", map[string]interface{}{
+		"size": size,
+	}, nil)
+	if err != nil {
+		return
+	}
+	var s string
+	if err = json.Unmarshal(result, &s); err != nil {
+		return
+	}
+	return parseAddress(s)
+}
+
+// Free releases memory returned by Alloc.
+func (m *Memory) Free(addr uint64) (err error) {
+	_, _, err = m.sess.agentCall("memory:free", map[string]interface{}{
+		"address": formatAddress(addr),
+	}, nil)
+	return
+}
@@ -0,0 +1,96 @@
+package fridago
+
+import (
+	"encoding/json"
+)
+
+// Module is a module mapped into a session's process.
+type Module struct {
+	Name string  `json:"name"`
+	Path string  `json:"path"`
+	Base Address `json:"base"`
+	Size uint64  `json:"size"`
+
+	sess *Session
+}
+
+type ModuleExport struct {
+	Type    string  `json:"type"`
+	Name    string  `json:"name"`
+	Address Address `json:"address"`
+}
+
+// ModuleImport is an imported symbol. Module, Address and Slot are empty
+// when the target cannot tell.
+type ModuleImport struct {
+	Type    string  `json:"type"`
+	Name    string  `json:"name"`
+	Module  string  `json:"module"`
+	Address Address `json:"address"`
+	Slot    Address `json:"slot"`
+}
+
+type ModuleSymbol struct {
+	IsGlobal bool    `json:"isGlobal"`
+	Type     string  `json:"type"`
+	Section  string  `json:"section"`
+	Name     string  `json:"name"`
+	Address  Address `json:"address"`
+	Size     uint64  `json:"size"`
+}
+
+// DebugSymbol describes the symbol covering an address.
+type DebugSymbol struct {
+	Address    Address `json:"address"`
+	Name       string  `json:"name"`
+	ModuleName string  `json:"moduleName"`
+	FileName   string  `json:"fileName"`
+	LineNumber int     `json:"lineNumber"`
+}
+
+// agentDecode runs op on the session's agent and decodes its result into v.
+func (sess *Session) agentDecode(op string, args interface{}, v interface{}) (err error) {
+	result, _, err := sess.agentCall(op, args, nil)
+	if err != nil {
+		return
+	}
+	return json.Unmarshal(result, v)
+}
+
+func (sess *Session) Modules() (ml []*Module, err error) {
+	if err = sess.agentDecode("module:list", nil, &ml); err != nil {
+		return
+	}
+	for _, m := range ml {
+		m.sess = sess
+	}
+	return
+}
+
+func (m *Module) Exports() (el []*ModuleExport, err error) {
+	err = m.sess.agentDecode("module:exports", map[string]string{"module": m.Name}, &el)
+	return
+}
+
+func (m *Module) Imports() (il []*ModuleImport, err error) {
+	err = m.sess.agentDecode("module:imports", map[string]string{"module": m.Name}, &il)
+	return
+}
+
+func (m *Module) Symbols() (sl []*ModuleSymbol, err error) {
+	err = m.sess.agentDecode("module:symbols", map[string]string{"module": m.Name}, &sl)
+	return
+}
+
+// ResolveSymbol finds the address of name, given as "symbol" or
+// "module!symbol". Exports are searched before debug symbols.
+func (sess *Session) ResolveSymbol(name string) (addr Address, err error) {
+	err = sess.agentDecode("symbol:resolve", map[string]string{"name": name}, &addr)
+	return
+}
+
+// SymbolAt describes the symbol covering addr.
+func (sess *Session) SymbolAt(addr Address) (s *DebugSymbol, err error) {
+	err = sess.agentDecode("symbol:at", map[string]string{"address": addr.String()}, &s)
+	return
+}
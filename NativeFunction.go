@@ -0,0 +1,98 @@
+package fridago
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// NativeType is the return type of a function called with CallFunction.
+type NativeType string
+
+const (
+	NativeVoid    NativeType = "void"
+	NativeInt     NativeType = "int64"
+	NativePointer NativeType = "pointer"
+	NativeDouble  NativeType = "double"
+)
+
+// NativeArg is an argument to CallFunction.
+type NativeArg struct {
+	typ   string
+	value interface{}
+	buf   []byte
+}
+
+func IntArg(v int64) NativeArg {
+	return NativeArg{typ: "int64", value: strconv.FormatInt(v, 10)}
+}
+
+func PointerArg(a Address) NativeArg {
+	return NativeArg{typ: "pointer", value: a.String()}
+}
+
+func DoubleArg(v float64) NativeArg {
+	return NativeArg{typ: "double", value: v}
+}
+
+// BufferArg passes a pointer to a copy of b in the target. Whatever the
+// function writes there is copied back into b once it returns.
+func BufferArg(b []byte) NativeArg {
+	return NativeArg{typ: "buffer", buf: b}
+}
+
+// todo:
+// calling on a chosen thread needs Process.runOnThread, which the
+// bundled Frida 12 runtime does not have
+
+// CallFunction calls the function at addr inside the target, on the
+// agent's own thread, and returns its result as an int64, an Address or a
+// float64 according to retType, or nil for NativeVoid.
+func (sess *Session) CallFunction(addr Address, retType NativeType, args ...NativeArg) (ret interface{}, err error) {
+	var (
+		specs []map[string]interface{}
+		data  []byte
+	)
+	for _, a := range args {
+		spec := map[string]interface{}{"type": a.typ}
+		if a.typ == "buffer" {
+			spec["size"] = len(a.buf)
+			data = append(data, a.buf...)
+		} else {
+			spec["value"] = a.value
+		}
+		specs = append(specs, spec)
+	}
+
+	result, rdata, err := sess.agentCall("native:call", map[string]interface{}{
+		"address": addr.String(),
+		"retType": retType,
+		"args":    specs,
+	}, data)
+	if err != nil {
+		return
+	}
+	for _, a := range args {
+		if a.typ == "buffer" {
+			rdata = rdata[copy(a.buf, rdata):]
+		}
+	}
+
+	switch retType {
+	case NativeVoid:
+		return nil, nil
+	case NativeDouble:
+		var v float64
+		err = json.Unmarshal(result, &v)
+		return v, err
+	case NativePointer:
+		var v Address
+		err = json.Unmarshal(result, &v)
+		return v, err
+	default:
+		var s string
+		if err = json.Unmarshal(result, &s); err != nil {
+			return
+		}
+		return strconv.ParseInt(s, 10, 64)
+	}
+}
@@ -0,0 +1,435 @@
+package fridago
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ScriptSpec describes a script an Orchestrator loads into each target.
+// Source is used as is; File is bundled with its imports.
+type ScriptSpec struct {
+	Name   string
+	Source string
+	File   string
+	// Setup runs before the script is loaded, to register message
+	// handlers and exports.
+	Setup func(t *Target, s *Script) error
+}
+
+// Rule selects targets and the scripts to load into them. Every non-nil
+// criterion must match.
+type Rule struct {
+	Name  string
+	Ident *regexp.Regexp
+	Path  *regexp.Regexp
+	Argv  *regexp.Regexp
+	Match func(t *Target) bool
+
+	Scripts []ScriptSpec
+	// FollowChildren enables child gating, so the children of matched
+	// targets are offered to the rules as well.
+	FollowChildren bool
+}
+
+func (r *Rule) matches(t *Target) bool {
+	if r.Ident != nil && !r.Ident.MatchString(t.Identifier) {
+		return false
+	}
+	if r.Path != nil && !r.Path.MatchString(t.Path) {
+		return false
+	}
+	if r.Argv != nil && !r.Argv.MatchString(strings.Join(t.Argv, " ")) {
+		return false
+	}
+	if r.Match != nil && !r.Match(t) {
+		return false
+	}
+	return true
+}
+
+const (
+	TargetFromProcess = "process"
+	TargetFromSpawn   = "spawn"
+	TargetFromChild   = "child"
+)
+
+// Target is a process offered to the rules. Path and Argv are only known
+// for children.
+type Target struct {
+	Pid        uint
+	ParentPid  uint
+	Identifier string
+	Path       string
+	Argv       []string
+	Origin     string
+
+	Session *Session
+	Scripts []*Script
+
+	// ready is closed once instrumenting the target is over
+	ready chan struct{}
+}
+
+type TargetEventType int
+
+const (
+	TargetMatched TargetEventType = iota
+	TargetAttached
+	TargetLoaded
+	TargetResumed
+	TargetExited
+	TargetFailed
+)
+
+func (t TargetEventType) String() string {
+	switch t {
+	case TargetMatched:
+		return "matched"
+	case TargetAttached:
+		return "attached"
+	case TargetLoaded:
+		return "loaded"
+	case TargetResumed:
+		return "resumed"
+	case TargetExited:
+		return "exited"
+	case TargetFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+type TargetEvent struct {
+	Type   TargetEventType
+	Target *Target
+	Rule   *Rule
+	Err    error
+}
+
+// Orchestrator watches a device for new processes, gated spawns and gated
+// children, attaches to those matching a rule, loads the rule's scripts
+// and only then lets gated processes resume.
+type Orchestrator struct {
+	Device *Device
+	Rules  []*Rule
+	// SpawnGating catches spawned processes before their first instruction.
+	SpawnGating bool
+	// AttachExisting also offers processes already running at start.
+	AttachExisting bool
+	PollInterval   time.Duration
+
+	events     chan TargetEvent
+	subscribed int32
+	over       chan struct{}
+	queue      *eventQueue[TargetEvent]
+	mu         sync.Mutex
+	active     map[uint]*Target
+	wg         sync.WaitGroup
+}
+
+func NewOrchestrator(d *Device, rules ...*Rule) *Orchestrator {
+	o := &Orchestrator{
+		Device:       d,
+		Rules:        rules,
+		PollInterval: time.Second,
+		events:       make(chan TargetEvent),
+		over:         make(chan struct{}),
+		queue:        newEventQueue[TargetEvent](),
+		active:       make(map[uint]*Target),
+	}
+	return o
+}
+
+// Events delivers the lifecycle of every matched target, in order and
+// without ever stalling the orchestrator. It is closed when Run returns
+// and every event has been read. Events nobody asked for are dropped once
+// Run returns.
+func (o *Orchestrator) Events() <-chan TargetEvent {
+	atomic.StoreInt32(&o.subscribed, 1)
+	return o.events
+}
+
+// forward feeds the queue to Events for the duration of Run, and after it
+// only if someone is reading.
+func (o *Orchestrator) forward() {
+	defer close(o.events)
+	for {
+		evt, ok := o.queue.pop()
+		if !ok {
+			return
+		}
+		select {
+		case o.events <- evt:
+		case <-o.over:
+			if atomic.LoadInt32(&o.subscribed) == 0 {
+				return
+			}
+			o.events <- evt
+		}
+	}
+}
+
+func (o *Orchestrator) emit(evt TargetEvent) {
+	log.WithFields(logrus.Fields{
+		"pid":    evt.Target.Pid,
+		"ident":  evt.Target.Identifier,
+		"origin": evt.Target.Origin,
+		"err":    evt.Err,
+	}).Debug("Orchestrator: target " + evt.Type.String())
+	o.queue.push(evt)
+}
+
+// Run drives the orchestrator until ctx is done, then disables spawn
+// gating and detaches from every target. It fails early if processes can
+// no longer be enumerated.
+func (o *Orchestrator) Run(ctx context.Context) (err error) {
+	go o.forward()
+	defer close(o.over)
+	defer o.queue.close()
+
+	spawns := make(chan *Spawn, 16)
+	children := make(chan *Child, 16)
+	if err = o.Device.On("child-added", children); err != nil {
+		return
+	}
+	if o.SpawnGating {
+		if err = o.Device.On("spawn-added", spawns); err == nil {
+			if err = o.Device.EnableSpawnGating(); err != nil {
+				o.Device.Off("spawn-added", spawns)
+			}
+		}
+		if err != nil {
+			o.Device.Off("child-added", children)
+			return
+		}
+	}
+	defer o.shutdown(spawns, children)
+
+	if o.SpawnGating {
+		pending, _ := o.Device.EnumeratePendingSpawnSync()
+		for _, s := range pending {
+			o.offer(&Target{Pid: s.Pid, Identifier: s.Identifier, Origin: TargetFromSpawn}, true)
+		}
+	}
+
+	var watchErr error
+	processes := o.Device.watchProcesses(ctx, o.PollInterval, func(err error) {
+		watchErr = err
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-spawns:
+			o.offer(&Target{Pid: s.Pid, Identifier: s.Identifier, Origin: TargetFromSpawn}, true)
+		case c := <-children:
+			if !o.isActive(c.ParentPid) {
+				// gated by someone else's session
+				continue
+			}
+			o.offer(&Target{
+				Pid:        c.Pid,
+				ParentPid:  c.ParentPid,
+				Identifier: c.Identifier,
+				Path:       c.Path,
+				Argv:       c.Argv,
+				Origin:     TargetFromChild,
+			}, true)
+		case evt, ok := <-processes:
+			if !ok {
+				// closed after watchErr is set, or because ctx is done
+				return watchErr
+			}
+			switch {
+			case evt.Type == ProcessExited:
+				o.exited(evt.Process.Pid)
+			case !evt.Initial || o.AttachExisting:
+				o.offer(&Target{Pid: evt.Process.Pid, Identifier: evt.Process.Name, Origin: TargetFromProcess}, false)
+			}
+		}
+	}
+}
+
+// offer hands t to the first matching rule. Gated targets that match no
+// rule are resumed right away.
+func (o *Orchestrator) offer(t *Target, gated bool) {
+	o.mu.Lock()
+	if _, seen := o.active[t.Pid]; seen {
+		o.mu.Unlock()
+		return
+	}
+	var rule *Rule
+	for _, r := range o.Rules {
+		if r.matches(t) {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		o.mu.Unlock()
+		if gated {
+			o.Device.Resume(t.Pid)
+		}
+		return
+	}
+	t.ready = make(chan struct{})
+	o.active[t.Pid] = t
+	o.mu.Unlock()
+
+	o.emit(TargetEvent{Type: TargetMatched, Target: t, Rule: rule})
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		defer close(t.ready)
+		o.instrument(t, rule, gated)
+	}()
+}
+
+func (o *Orchestrator) instrument(t *Target, rule *Rule, gated bool) {
+	err := o.attach(t, rule)
+	if err != nil {
+		o.emit(TargetEvent{Type: TargetFailed, Target: t, Rule: rule, Err: err})
+	}
+	if gated {
+		if rerr := o.Device.Resume(t.Pid); rerr != nil {
+			o.emit(TargetEvent{Type: TargetFailed, Target: t, Rule: rule, Err: rerr})
+			return
+		}
+		o.emit(TargetEvent{Type: TargetResumed, Target: t, Rule: rule})
+	}
+}
+
+func (o *Orchestrator) attach(t *Target, rule *Rule) (err error) {
+	sess, err := o.Device.Attach(t.Pid)
+	if err != nil {
+		return
+	}
+	t.Session = sess
+	o.emit(TargetEvent{Type: TargetAttached, Target: t, Rule: rule})
+
+	if rule.FollowChildren {
+		if err = sess.EnableChildGating(); err != nil {
+			return
+		}
+	}
+	for _, spec := range rule.Scripts {
+		var s *Script
+		if spec.File != "" {
+			s, err = sess.CreateScriptFromFileSync(spec.Name, spec.File, nil)
+		} else {
+			s, err = sess.CreateScriptSync(spec.Name, spec.Source)
+		}
+		if err != nil {
+			return
+		}
+		if s == nil {
+			return NewErrorAndLog("Orchestrator: create script failed")
+		}
+		if spec.Setup != nil {
+			if err = spec.Setup(t, s); err != nil {
+				return
+			}
+		}
+		if err = s.Load(); err != nil {
+			return
+		}
+		t.Scripts = append(t.Scripts, s)
+	}
+	o.emit(TargetEvent{Type: TargetLoaded, Target: t, Rule: rule})
+	return
+}
+
+func (o *Orchestrator) isActive(pid uint) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.active[pid]
+	return ok
+}
+
+func (o *Orchestrator) exited(pid uint) {
+	o.mu.Lock()
+	t, ok := o.active[pid]
+	delete(o.active, pid)
+	o.mu.Unlock()
+	if !ok {
+		return
+	}
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		<-t.ready
+		o.release(t)
+		o.emit(TargetEvent{Type: TargetExited, Target: t})
+	}()
+}
+
+// release unloads the target's scripts and detaches its session.
+func (o *Orchestrator) release(t *Target) {
+	for _, s := range t.Scripts {
+		s.UnLoad()
+	}
+	t.Scripts = nil
+	if t.Session != nil && !t.Session.IsDetached() {
+		t.Session.Detach()
+	}
+}
+
+// shutdown stops gating and leaves nothing suspended: spawns and children
+// caught but not yet offered are resumed, and so is whatever frida still
+// holds for us. Then every target is released.
+func (o *Orchestrator) shutdown(spawns chan *Spawn, children chan *Child) {
+	o.Device.Off("child-added", children)
+	if o.SpawnGating {
+		o.Device.Off("spawn-added", spawns)
+		if err := o.Device.DisableSpawnGating(); err != nil {
+			log.WithField("err", err).Error("Orchestrator: disable spawn gating failed")
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case s := <-spawns:
+			o.Device.Resume(s.Pid)
+		case c := <-children:
+			if o.isActive(c.ParentPid) {
+				o.Device.Resume(c.Pid)
+			}
+		default:
+			drained = true
+		}
+	}
+	o.wg.Wait()
+
+	o.mu.Lock()
+	targets := o.active
+	o.active = make(map[uint]*Target)
+	o.mu.Unlock()
+
+	for _, t := range targets {
+		if t.Session != nil && !t.Session.IsDetached() {
+			t.Session.DisableChildGating()
+		}
+	}
+	if pending, err := o.Device.EnumeratePendingChildrenSync(); err == nil {
+		for _, c := range pending {
+			if _, ours := targets[c.ParentPid]; ours {
+				o.Device.Resume(c.Pid)
+			}
+		}
+	}
+	if o.SpawnGating {
+		if pending, err := o.Device.EnumeratePendingSpawnSync(); err == nil {
+			for _, s := range pending {
+				o.Device.Resume(s.Pid)
+			}
+		}
+	}
+	for _, t := range targets {
+		o.release(t)
+	}
+}
@@ -4,6 +4,17 @@ package fridago
  #include "frida-core.h"
 */
 import "C"
+import (
+	"path"
+	"regexp"
+	"time"
+)
+
+// ProcessPollInterval is how often WaitForProcess re-enumerates processes.
+var ProcessPollInterval = 250 * time.Millisecond
+
+// ProcessMatcher selects processes for FindProcesses and WaitForProcess.
+type ProcessMatcher func(p *Process) bool
 
 type Process struct {
 	ptr  *C.FridaProcess
@@ -23,8 +34,80 @@ func (p *Process) fromFridaProcess() (err error) {
 	return
 }
 
+func MatchProcessName(name string) ProcessMatcher {
+	return func(p *Process) bool {
+		return p.Name == name
+	}
+}
+
+// MatchProcessGlob matches process names against a shell pattern such as
+// "com.example.*".
+func MatchProcessGlob(pattern string) ProcessMatcher {
+	return func(p *Process) bool {
+		ok, _ := path.Match(pattern, p.Name)
+		return ok
+	}
+}
+
+func MatchProcessRegexp(re *regexp.Regexp) ProcessMatcher {
+	return func(p *Process) bool {
+		return re.MatchString(p.Name)
+	}
+}
+
+func MatchProcessPid(pid uint) ProcessMatcher {
+	return func(p *Process) bool {
+		return p.Pid == pid
+	}
+}
+
 func NewProcess(fp *C.FridaProcess) (p *Process, err error) {
 	p = &Process{ptr: fp}
 	err = p.fromFridaProcess()
 	return
 }
+
+type ProcessEventType int
+
+const (
+	ProcessStarted ProcessEventType = iota
+	ProcessExited
+)
+
+func (t ProcessEventType) String() string {
+	switch t {
+	case ProcessStarted:
+		return "started"
+	case ProcessExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+// ProcessEvent reports a process seen starting or exiting between two
+// snapshots. A pid reused by a process with another name is reported as
+// an exit followed by a start.
+type ProcessEvent struct {
+	Type    ProcessEventType
+	Process *Process
+	Initial bool
+}
+
+// diffProcesses compares two snapshots keyed by pid and name.
+func diffProcesses(prev map[uint]*Process, cur []*Process) (next map[uint]*Process, events []ProcessEvent) {
+	next = make(map[uint]*Process, len(cur))
+	for _, p := range cur {
+		next[p.Pid] = p
+	}
+	for pid, old := range prev {
+		if p, ok := next[pid]; !ok || p.Name != old.Name {
+			events = append(events, ProcessEvent{Type: ProcessExited, Process: old})
+		}
+	}
+	for _, p := range cur {
+		if old, ok := prev[p.Pid]; !ok || old.Name != p.Name {
+			events = append(events, ProcessEvent{Type: ProcessStarted, Process: p})
+		}
+	}
+	return
+}
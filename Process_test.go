@@ -0,0 +1,86 @@
+package fridago
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func procs(ps ...*Process) map[uint]*Process {
+	m := make(map[uint]*Process, len(ps))
+	for _, p := range ps {
+		m[p.Pid] = p
+	}
+	return m
+}
+
+func eventStrings(events []ProcessEvent) []string {
+	var sl []string
+	for _, e := range events {
+		sl = append(sl, fmt.Sprintf("%s %d %s", e.Type, e.Process.Pid, e.Process.Name))
+	}
+	sort.Strings(sl)
+	return sl
+}
+
+func TestDiffProcesses(t *testing.T) {
+	a := &Process{Pid: 1, Name: "a"}
+	b := &Process{Pid: 2, Name: "b"}
+	c := &Process{Pid: 3, Name: "c"}
+	b2 := &Process{Pid: 2, Name: "b2"}
+
+	tests := []struct {
+		name string
+		prev map[uint]*Process
+		cur  []*Process
+		want []string
+	}{
+		{"first snapshot", nil, []*Process{a, b}, []string{"started 1 a", "started 2 b"}},
+		{"unchanged", procs(a, b), []*Process{a, b}, nil},
+		{"started", procs(a), []*Process{a, c}, []string{"started 3 c"}},
+		{"exited", procs(a, b), []*Process{a}, []string{"exited 2 b"}},
+		{"pid reused", procs(a, b), []*Process{a, b2}, []string{"exited 2 b", "started 2 b2"}},
+		{"all gone", procs(a, b), nil, []string{"exited 1 a", "exited 2 b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, events := diffProcesses(tt.prev, tt.cur)
+			got := eventStrings(events)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+			if len(next) != len(tt.cur) {
+				t.Errorf("next has %d processes, want %d", len(next), len(tt.cur))
+			}
+			for _, p := range tt.cur {
+				if next[p.Pid] != p {
+					t.Errorf("next[%d] = %v, want %v", p.Pid, next[p.Pid], p)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessMatchers(t *testing.T) {
+	p := &Process{Pid: 42, Name: "com.example.app"}
+	tests := []struct {
+		name  string
+		match ProcessMatcher
+		want  bool
+	}{
+		{"name", MatchProcessName("com.example.app"), true},
+		{"other name", MatchProcessName("com.example"), false},
+		{"glob", MatchProcessGlob("com.example.*"), true},
+		{"other glob", MatchProcessGlob("org.*"), false},
+		{"regexp", MatchProcessRegexp(regexp.MustCompile(`example\.a`)), true},
+		{"other regexp", MatchProcessRegexp(regexp.MustCompile(`^example`)), false},
+		{"pid", MatchProcessPid(42), true},
+		{"other pid", MatchProcessPid(7), false},
+	}
+	for _, tt := range tests {
+		if got := tt.match(p); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
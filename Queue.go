@@ -0,0 +1,55 @@
+package fridago
+
+import (
+	"sync"
+)
+
+// eventQueue is an unbounded FIFO, so that signal callbacks running on the
+// frida main loop never block on a slow consumer.
+type eventQueue[T any] struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []T
+	closed bool
+}
+
+func newEventQueue[T any]() *eventQueue[T] {
+	q := new(eventQueue[T])
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *eventQueue[T]) push(item T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, item)
+	q.cond.Signal()
+}
+
+// pop blocks until an item is available. It returns false once the queue
+// is closed and drained.
+func (q *eventQueue[T]) pop() (item T, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return
+	}
+	var zero T
+	item = q.items[0]
+	q.items[0] = zero
+	q.items = q.items[1:]
+	return item, true
+}
+
+func (q *eventQueue[T]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,146 @@
+package fridago
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	RecordIn  = "in"
+	RecordOut = "out"
+)
+
+// RecordEntry is one line of a message recording. Direction is RecordIn
+// for agent messages and RecordOut for host posts.
+type RecordEntry struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	ScriptID  uint      `json:"script_id"`
+	Script    string    `json:"script"`
+	Message   string    `json:"message"`
+	Data      []byte    `json:"data,omitempty"`
+}
+
+// Recorder writes every message exchanged with agents to a JSONL file.
+type Recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+var (
+	recorderMu sync.Mutex
+	recorder   *Recorder
+)
+
+func NewRecorder(path string) (r *Recorder, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	r = &Recorder{
+		f:   f,
+		enc: json.NewEncoder(f),
+	}
+	return
+}
+
+func (r *Recorder) Record(e *RecordEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(e)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// SetRecorder installs r to capture the messages of every script. Passing
+// nil stops recording.
+func SetRecorder(r *Recorder) {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
+	recorder = r
+}
+
+func recordMessage(direction string, scr *Script, msg string, data []byte) {
+	recorderMu.Lock()
+	r := recorder
+	recorderMu.Unlock()
+	if r == nil {
+		return
+	}
+	err := r.Record(&RecordEntry{
+		Time:      time.Now(),
+		Direction: direction,
+		ScriptID:  scr.ID,
+		Script:    scr.Name,
+		Message:   msg,
+		Data:      data,
+	})
+	if err != nil {
+		log.WithField("err", err).Error("Recorder: write failed")
+	}
+}
+
+// NewReplayScript returns a Script backed by no agent, for feeding a
+// recording through message handlers with Replay. Its posts succeed and
+// are only recorded, Load and Eternalize do nothing, and RpcCall fails with
+// ErrNotSupported.
+func NewReplayScript(name string) *Script {
+	s := &Script{
+		Name:       name,
+		replay:     true,
+		queue:      newEventQueue[*rawMessage](),
+		dispatched: make(chan struct{}),
+		exports:    make(map[string]ExportFunc),
+	}
+	s.handlers = newHandlerSet(s)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+type ReplayOptions struct {
+	// Script only replays messages recorded from scripts with this name.
+	Script string
+	// Realtime keeps the original delays between messages.
+	Realtime bool
+}
+
+// Replay dispatches the agent messages recorded in path to scr, in order,
+// and returns once all of them have been handled.
+func Replay(path string, scr *Script, opts *ReplayOptions) (err error) {
+	if opts == nil {
+		opts = &ReplayOptions{}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var last time.Time
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<30)
+	for sc.Scan() {
+		e := new(RecordEntry)
+		if err = json.Unmarshal(sc.Bytes(), e); err != nil {
+			return
+		}
+		if e.Direction != RecordIn || (opts.Script != "" && e.Script != opts.Script) {
+			continue
+		}
+		if opts.Realtime && !last.IsZero() && e.Time.After(last) {
+			time.Sleep(e.Time.Sub(last))
+		}
+		last = e.Time
+		scr.dispatch(&rawMessage{e.Message, e.Data})
+	}
+	return sc.Err()
+}
@@ -0,0 +1,154 @@
+package fridago
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ScanRange is a memory range considered by Session.Scan.
+type ScanRange struct {
+	Base       Address `json:"base"`
+	Size       uint64  `json:"size"`
+	Protection string  `json:"protection"`
+	File       string  `json:"file"`
+}
+
+// ScanFilter selects the ranges Session.Scan searches. Protection is the
+// minimum protection, "r--" by default; Module restricts the scan to one
+// module's ranges.
+type ScanFilter struct {
+	Protection string
+	Module     string
+	Match      func(r *ScanRange) bool
+}
+
+// ScanProgress reports that a range has been fully scanned.
+type ScanProgress struct {
+	Range   int
+	Ranges  int
+	Matches int
+}
+
+// Match is a scan result. Progress is set instead of Address for progress
+// reports, and Err for a range that could not be scanned or a failed scan.
+type Match struct {
+	Address  Address
+	Size     int
+	Range    *ScanRange
+	Progress *ScanProgress
+	Err      error
+}
+
+type scanEvent struct {
+	Type    string  `json:"type"`
+	Range   int     `json:"range"`
+	Address Address `json:"address"`
+	Size    int     `json:"size"`
+	Matches int     `json:"matches"`
+	Error   string  `json:"error"`
+}
+
+// Scan searches the readable memory of the session's process for pattern,
+// given as hex bytes with "??" wildcards, e.g. "48 8b ?? 24". The channel
+// is closed when the scan completes; cancelling ctx stops the agent-side
+// scan as well. Reading the channel slowly never stalls the session's other
+// agent calls: events queue up on the Go side until they are read.
+func (sess *Session) Scan(ctx context.Context, pattern string, filter *ScanFilter) (<-chan Match, error) {
+	if filter == nil {
+		filter = &ScanFilter{}
+	}
+	protection := filter.Protection
+	if protection == "" {
+		protection = "r--"
+	}
+
+	var all []*ScanRange
+	err := sess.agentDecode("scan:ranges", map[string]string{
+		"protection": protection,
+		"module":     filter.Module,
+	}, &all)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []*ScanRange
+	for _, r := range all {
+		if filter.Match == nil || filter.Match(r) {
+			ranges = append(ranges, r)
+		}
+	}
+
+	req, err := sess.agentStart("scan:run", map[string]interface{}{
+		"pattern": pattern,
+		"ranges":  ranges,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan Match)
+	go func() {
+		defer close(out)
+		defer req.finish()
+
+		emit := func(m Match) {
+			select {
+			case out <- m:
+			case <-ctx.Done():
+			}
+		}
+		cancelled := false
+		for {
+			var res *rpcResult
+			select {
+			case res = <-req.results:
+			case <-ctx.Done():
+				if !cancelled {
+					cancelled = true
+					req.cancel()
+				}
+				// keep draining until the agent confirms it stopped
+				select {
+				case res = <-req.results:
+				case <-req.scr.ctx.Done():
+					return
+				}
+			case <-req.scr.ctx.Done():
+				emit(Match{Err: ErrInvalidOperation})
+				return
+			}
+
+			if res.operation != "event" {
+				if _, _, err := agentResult("scan:run", res); err != nil && !cancelled {
+					emit(Match{Err: err})
+				}
+				return
+			}
+			if cancelled {
+				continue
+			}
+			var evt scanEvent
+			if len(res.params) > 0 {
+				b, _ := json.Marshal(res.params[0])
+				json.Unmarshal(b, &evt)
+			}
+			if evt.Range < 0 || evt.Range >= len(ranges) {
+				continue
+			}
+			r := ranges[evt.Range]
+			switch evt.Type {
+			case "match":
+				emit(Match{Address: evt.Address, Size: evt.Size, Range: r})
+			case "progress":
+				emit(Match{Range: r, Progress: &ScanProgress{
+					Range:   evt.Range,
+					Ranges:  len(ranges),
+					Matches: evt.Matches,
+				}})
+			case "error":
+				emit(Match{Range: r, Err: fmt.Errorf("Scan: %s at %s", evt.Error, r.Base)})
+			}
+		}
+	}()
+	return out, nil
+}
@@ -1,11 +1,16 @@
 package fridago
 
 /*
+ #include <stdlib.h>
  #include "frida-core.h"
- extern void _on_message(FridaScript * script, const gchar * message, GBytes * data, gpointer user_data);
+ extern gulong _connect_message(FridaScript * script, guint handle);
+ extern gulong _connect_signal(gpointer instance, const gchar * sig, GCallback cb, guint handle);
+ extern void _on_script_destroyed(FridaScript * script, gpointer user_data);
 */
 import "C"
 import (
+	"context"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -16,10 +21,16 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// HostCallSource is the agent-side helper for Script.Export. Prepend it to
+// the agent source to get a global `host` with `host.call(name, ...args)`.
+//
+//go:embed js/host.js
+var HostCallSource string
+
 var (
-	chRawMessage chan *rawMessage
-	cbs          sync.Map
-	reqIDNum     uint64 = 0
+	scripts     sync.Map
+	scriptIDNum uint64 = 0
+	reqIDNum    uint64 = 0
 )
 
 const (
@@ -32,15 +43,132 @@ type Script struct {
 	ptr  *C.FridaScript
 	ID   uint
 	Name string
+	Pid  uint
+
+	handle      uint
+	handlerID   C.gulong
+	destroyedID C.gulong
+	closeOnce   sync.Once
+	replay      bool
+	queue       *eventQueue[*rawMessage]
+	// dispatched is closed once the last message has been dispatched
+	dispatched chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	handlers   *handlerSet
+	logHandler LogHandler
+	errHandler func(*ScriptError)
+	exports    map[string]ExportFunc
+	sourceMap  *SourceMap
+	streams    *streamHub
+	rpcCalls   sync.Map
+}
+
+// messageHandler receives the agent's messages. close, if set, runs once
+// the script is gone for good and its last message has been handled.
+type messageHandler struct {
+	fn    func(*Message)
+	close func()
+}
+
+// handlerSet holds a script's message handlers. A reloaded script shares
+// the set of the script it replaces, so while both run their messages are
+// still handled one at a time and indices keep counting. Only the owner
+// closes the handlers when it goes away.
+type handlerSet struct {
+	mu       sync.Mutex
+	handlers []*messageHandler
+	owner    *Script
+
+	deliverMu sync.Mutex
+	index     uint64
+}
+
+func newHandlerSet(owner *Script) *handlerSet {
+	return &handlerSet{owner: owner}
+}
+
+func (hs *handlerSet) list() []*messageHandler {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return append([]*messageHandler{}, hs.handlers...)
+}
+
+func (hs *handlerSet) deliver(msg *Message) {
+	hs.deliverMu.Lock()
+	defer hs.deliverMu.Unlock()
+	msg.Index = hs.index
+	hs.index++
+	for _, h := range hs.list() {
+		h.fn(msg)
+	}
+}
+
+// ExportFunc serves an agent's host.call. A returned error is raised as a
+// JS exception in the agent.
+type ExportFunc func(ctx context.Context, args []json.RawMessage) (interface{}, error)
+
+type hostCall struct {
+	ID   uint64
+	Name string
+	Args []json.RawMessage
+}
+
+type hostReply struct {
+	Type   string      `json:"type"`
+	ID     uint64      `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *string     `json:"error,omitempty"`
+}
+
+type LogLevel string
+
+const (
+	LogLevelDebug   LogLevel = "debug"
+	LogLevelInfo    LogLevel = "info"
+	LogLevelWarning LogLevel = "warning"
+	LogLevelError   LogLevel = "error"
+)
+
+type LogHandler func(level LogLevel, text string)
+
+// ScriptError is an uncaught exception reported by the agent. Positions
+// are in original sources when the script has a source map.
+type ScriptError struct {
+	Description  string `json:"description"`
+	Stack        string `json:"stack"`
+	FileName     string `json:"fileName"`
+	LineNumber   int    `json:"lineNumber"`
+	ColumnNumber int    `json:"columnNumber"`
+}
+
+func (e *ScriptError) Error() string {
+	if e.FileName == "" {
+		return e.Description
+	}
+	return fmt.Sprintf("%s (%s:%d:%d)", e.Description, e.FileName, e.LineNumber, e.ColumnNumber)
 }
 
 type Message struct {
 	Index    uint64
 	Msg      interface{}
+	Payload  json.RawMessage
 	Data     []byte
 	UserData uintptr
 }
 
+// TypedMessage is a message whose send payload was decoded into T. Err is
+// set, and Payload left zero, when decoding failed.
+type TypedMessage[T any] struct {
+	Index   uint64
+	Payload T
+	Data    []byte
+	Err     error
+}
+
 type rpcResult struct {
 	operation string
 	params    []interface{}
@@ -48,90 +176,166 @@ type rpcResult struct {
 }
 
 type rawMessage struct {
-	scriptID uint
-	msg      string
-	data     []byte
-	userData uintptr
-}
-
-func init() {
-	chRawMessage = make(chan *rawMessage, 1000)
-	cbs = sync.Map{}
-	go msgDispatch()
+	msg  string
+	data []byte
 }
 
 //export onMessage
 func onMessage(script *C.FridaScript, message *C.gchar, data *C.GBytes, userData C.gpointer) {
+	v, ok := scripts.Load(uint(uintptr(userData)))
+	if !ok {
+		return
+	}
 	msg := C.GoString(message)
-	id := C.frida_script_get_id(script)
 	var dBytes []byte
 	if !IsNullCPointer(unsafe.Pointer(data)) {
 		var dSize C.ulong
 		dBuf := C.g_bytes_get_data(data, &dSize)
 		dBytes = C.GoBytes(unsafe.Pointer(dBuf), C.int(dSize))
 	}
-	// todo: userData
-	chRawMessage <- &rawMessage{uint(id), msg, dBytes, 0}
+	v.(*Script).queue.push(&rawMessage{msg, dBytes})
 }
 
-func msgDispatch() {
-	var index uint64 = 0
-	for rawMsg := range chRawMessage {
-		if !json.Valid([]byte(rawMsg.msg)) {
-			continue
+//export onScriptDestroyed
+func onScriptDestroyed(script *C.FridaScript, userData C.gpointer) {
+	if v, ok := scripts.Load(uint(uintptr(userData))); ok {
+		v.(*Script).teardown()
+	}
+}
+
+// teardown releases the Go side of the script, once, whether it was
+// unloaded or destroyed along with its session. Pending messages are still
+// dispatched.
+func (scr *Script) teardown() {
+	scr.closeOnce.Do(func() {
+		scr.cancel()
+		scripts.Delete(scr.handle)
+		scr.queue.close()
+		if scr.replay {
+			// Replay dispatches synchronously, so nothing is left
+			close(scr.dispatched)
 		}
-		jsobj := make(map[string]interface{})
-		json.Unmarshal([]byte(rawMsg.msg), &jsobj)
-
-		switch t := jsobj["type"].(string); t {
-		case "log":
-			level := jsobj["level"].(string)
-			text := jsobj["payload"].(string)
-			/* todo: log_handler */
-			log.WithFields(logrus.Fields{
-				"level": level,
-				"text":  text,
-			}).Info("Logger")
-		case "send":
-			payload, isList := jsobj["payload"].([]interface{})
-			if isList && payload[0].(string) == "frida:rpc" {
-				reqID := payload[1].(string)
-				key := fmt.Sprintf("%d_%s", rawMsg.scriptID, reqID)
-				cbv, _ := cbs.Load(key)
-				if cb, ok := cbv.(chan *rpcResult); ok {
-					operation := payload[2].(string)
-					cb <- &rpcResult{operation, payload[3:], rawMsg.data}
-				}
-			} else {
-				key := fmt.Sprintf("%d_%s", rawMsg.scriptID, "message")
-				cbv, _ := cbs.Load(key)
-				if cb, ok := cbv.(chan *Message); ok {
-					cb <- &Message{index, jsobj["payload"], rawMsg.data, 0}
+	})
+}
+
+// msgDispatch delivers the script's messages in order on its own goroutine.
+func (scr *Script) msgDispatch() {
+	defer close(scr.dispatched)
+	defer scr.closeHandlers()
+	for {
+		rawMsg, ok := scr.queue.pop()
+		if !ok {
+			return
+		}
+		// recorded here rather than in onMessage, which must never block
+		recordMessage(RecordIn, scr, rawMsg.msg, rawMsg.data)
+		scr.dispatch(rawMsg)
+	}
+}
+
+func (scr *Script) dispatch(rawMsg *rawMessage) {
+	if !json.Valid([]byte(rawMsg.msg)) {
+		return
+	}
+	jsobj := make(map[string]interface{})
+	json.Unmarshal([]byte(rawMsg.msg), &jsobj)
+
+	switch t, _ := jsobj["type"].(string); t {
+	case "log":
+		level, _ := jsobj["level"].(string)
+		text, _ := jsobj["payload"].(string)
+		scr.mu.Lock()
+		handler := scr.logHandler
+		scr.mu.Unlock()
+		if handler == nil {
+			handler = scr.defaultLogHandler
+		}
+		if sm := scr.getSourceMap(); sm != nil {
+			text = sm.Rewrite(text, scr.fileName())
+		}
+		handler(LogLevel(level), text)
+	case "error":
+		e := new(ScriptError)
+		json.Unmarshal([]byte(rawMsg.msg), e)
+		if sm := scr.getSourceMap(); sm != nil {
+			if e.FileName == scr.fileName() {
+				if source, line, col, ok := sm.Lookup(e.LineNumber, e.ColumnNumber); ok {
+					e.FileName, e.LineNumber, e.ColumnNumber = source, line, col
 				}
-				index++
 			}
+			e.Stack = sm.Rewrite(e.Stack, scr.fileName())
+		}
+		scr.mu.Lock()
+		handler := scr.errHandler
+		scr.mu.Unlock()
+		if handler == nil {
+			handler = scr.defaultErrorHandler
+		}
+		handler(e)
+	case "send":
+		payload, isList := jsobj["payload"].([]interface{})
+		if isList && len(payload) > 0 && payload[0] == "frida:host" {
+			call, err := parseHostCall(rawMsg.msg)
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"script": scr.Name,
+					"err":    err,
+				}).Error("Script: bad host call")
+				return
+			}
+			go scr.serveHostCall(call)
+		} else if isList && len(payload) > 2 && payload[0] == "frida:stream" {
+			scr.handleStream(rawMsg)
+		} else if isList && len(payload) > 2 && payload[0] == "frida:upload" {
+			scr.handleUpload(rawMsg)
+		} else if isList && len(payload) > 3 && (payload[0] == "frida:rpc" || payload[0] == "frida:agent") {
+			reqID, _ := payload[1].(string)
+			operation, _ := payload[2].(string)
+			res := &rpcResult{operation, payload[3:], rawMsg.data}
+			cbv, _ := scr.rpcCalls.Load(reqID)
+			switch cb := cbv.(type) {
+			case chan *rpcResult:
+				cb <- res
+			case *eventQueue[*rpcResult]:
+				cb.push(res)
+			}
+		} else {
+			var envelope struct {
+				Payload json.RawMessage `json:"payload"`
+			}
+			json.Unmarshal([]byte(rawMsg.msg), &envelope)
+			scr.handlerSet().deliver(&Message{
+				Msg:     jsobj["payload"],
+				Payload: envelope.Payload,
+				Data:    rawMsg.data,
+			})
 		}
 	}
 }
 
+// IsDestroyed reports whether the script is gone. A replay script is
+// destroyed once unloaded.
 func (scr *Script) IsDestroyed() bool {
+	if scr.replay {
+		return scr.ctx.Err() != nil
+	}
+	if scr.ptr == nil {
+		return true
+	}
 	return GbooleanToGoBool(C.frida_script_is_destroyed(scr.ptr))
 }
 
-func (scr *Script) connectSignal(sig string, cb unsafe.Pointer) {
-	C.g_signal_connect_data(C.gpointer(scr.ptr),
-		C.CString(sig), C.GCallback(cb),
-		nil, nil, 0)
-}
-
 func (scr *Script) On(sig string, ch interface{}) (err error) {
 	switch sig {
 	case "message":
-		if v, ok := ch.(chan *Message); ok {
-			key := fmt.Sprintf("%d_%s", scr.ID, sig)
-			cbs.Store(key, v)
+		v, ok := ch.(chan *Message)
+		if !ok {
+			err = NewErrorAndLog("Script: message handler must be a chan *Message")
+			return
 		}
-		scr.connectSignal("message", unsafe.Pointer(C._on_message))
+		scr.addMessageHandler(func(msg *Message) {
+			v <- msg
+		})
 	default:
 		err = NewErrorAndLog("Script: signal unspported")
 		log.WithFields(logrus.Fields{
@@ -141,19 +345,320 @@ func (scr *Script) On(sig string, ch interface{}) (err error) {
 	return
 }
 
-func (scr *Script) UnLoad() error {
+// OnMessage registers fn for every message the agent sends with send().
+// Handlers run on a dispatcher goroutine, one message at a time, and must
+// not block. A script reloaded by ScriptFile keeps its handlers, and the
+// message index keeps counting from where the old script left off.
+func (scr *Script) OnMessage(fn func(msg Message)) {
+	scr.addMessageHandler(func(msg *Message) {
+		fn(*msg)
+	})
+}
+
+// Subscribe decodes the payload of every message the agent sends into T.
+// Messages are queued, so a slow reader never holds up the script. The
+// channel is closed when ctx is done or the script is unloaded.
+func Subscribe[T any](ctx context.Context, scr *Script) <-chan *TypedMessage[T] {
+	q := newEventQueue[*TypedMessage[T]]()
+	ch := make(chan *TypedMessage[T])
+	done := make(chan struct{})
+	var once sync.Once
+	h := &messageHandler{}
+	stop := func() {
+		once.Do(func() {
+			scr.removeHandler(h)
+			close(done)
+			q.close()
+		})
+	}
+
+	h.close = stop
+	h.fn = func(msg *Message) {
+		if ctx.Err() != nil {
+			return
+		}
+		tm := &TypedMessage[T]{
+			Index: msg.Index,
+			Data:  msg.Data,
+		}
+		if err := json.Unmarshal(msg.Payload, &tm.Payload); err != nil {
+			tm.Err = err
+			log.WithFields(logrus.Fields{
+				"script": scr.Name,
+				"index":  msg.Index,
+				"err":    err,
+			}).Error("Script: decode message payload failed")
+		}
+		q.push(tm)
+	}
+	scr.addHandler(h)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(ch)
+		for {
+			tm, ok := q.pop()
+			if !ok {
+				return
+			}
+			select {
+			case ch <- tm:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (scr *Script) addMessageHandler(fn func(*Message)) {
+	scr.addHandler(&messageHandler{fn: fn})
+}
+
+func (scr *Script) handlerSet() *handlerSet {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	return scr.handlers
+}
+
+func (scr *Script) addHandler(h *messageHandler) {
+	hs := scr.handlerSet()
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.handlers = append(hs.handlers, h)
+}
+
+func (scr *Script) removeHandler(h *messageHandler) {
+	hs := scr.handlerSet()
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for i, hh := range hs.handlers {
+		if hh == h {
+			hs.handlers = append(hs.handlers[:i:i], hs.handlers[i+1:]...)
+			return
+		}
+	}
+}
+
+// closeHandlers tells the handlers the script is gone, unless they have
+// passed to a replacement script.
+func (scr *Script) closeHandlers() {
+	hs := scr.handlerSet()
+	hs.mu.Lock()
+	var handlers []*messageHandler
+	if hs.owner == scr {
+		handlers = append(handlers, hs.handlers...)
+	}
+	hs.mu.Unlock()
+	for _, h := range handlers {
+		if h.close != nil {
+			h.close()
+		}
+	}
+}
+
+// SetLogHandler replaces the handler receiving the agent's console output.
+// Passing nil restores the default, which forwards to the package logger.
+func (scr *Script) SetLogHandler(handler LogHandler) {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	scr.logHandler = handler
+}
+
+func (scr *Script) defaultLogHandler(level LogLevel, text string) {
+	entry := log.WithFields(logrus.Fields{
+		"script": scr.Name,
+		"pid":    scr.Pid,
+	})
+	switch level {
+	case LogLevelDebug:
+		entry.Debug(text)
+	case LogLevelWarning:
+		entry.Warn(text)
+	case LogLevelError:
+		entry.Error(text)
+	default:
+		entry.Info(text)
+	}
+}
+
+// OnError replaces the handler for uncaught agent exceptions. Passing nil
+// restores the default, which logs them.
+func (scr *Script) OnError(handler func(e *ScriptError)) {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	scr.errHandler = handler
+}
+
+func (scr *Script) defaultErrorHandler(e *ScriptError) {
+	log.WithFields(logrus.Fields{
+		"script": scr.Name,
+		"pid":    scr.Pid,
+		"file":   e.FileName,
+		"line":   e.LineNumber,
+		"column": e.ColumnNumber,
+		"stack":  e.Stack,
+	}).Error(e.Description)
+}
+
+// SetSourceMap makes errors and console output of the script refer to the
+// original sources described by sm.
+func (scr *Script) SetSourceMap(sm *SourceMap) {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	scr.sourceMap = sm
+}
+
+// fileName is the name frida gives the script's source in stack traces.
+func (scr *Script) fileName() string {
+	return "/" + scr.Name + ".js"
+}
+
+func (scr *Script) getSourceMap() *SourceMap {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	return scr.sourceMap
+}
+
+// Export registers fn under name for agents calling host.call(name, ...).
+// Calls are served concurrently, each on its own goroutine.
+func (scr *Script) Export(name string, fn ExportFunc) {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	scr.exports[name] = fn
+}
+
+func parseHostCall(msg string) (call *hostCall, err error) {
+	var envelope struct {
+		Payload []json.RawMessage `json:"payload"`
+	}
+	if err = json.Unmarshal([]byte(msg), &envelope); err != nil {
+		return
+	}
+	if len(envelope.Payload) < 5 {
+		err = ErrProtocolError
+		return
+	}
+	call = new(hostCall)
+	if err = json.Unmarshal(envelope.Payload[1], &call.ID); err != nil {
+		return
+	}
+	if err = json.Unmarshal(envelope.Payload[3], &call.Name); err != nil {
+		return
+	}
+	err = json.Unmarshal(envelope.Payload[4], &call.Args)
+	return
+}
+
+func (scr *Script) serveHostCall(call *hostCall) {
+	reply := &hostReply{Type: "frida:host", ID: call.ID}
+
+	scr.mu.Lock()
+	fn, ok := scr.exports[call.Name]
+	scr.mu.Unlock()
+	if ok {
+		result, err := fn(scr.ctx, call.Args)
+		if err != nil {
+			msg := err.Error()
+			reply.Error = &msg
+		} else {
+			reply.Result = result
+		}
+	} else {
+		msg := fmt.Sprintf("host: no export named '%s'", call.Name)
+		reply.Error = &msg
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		msg := err.Error()
+		b, _ = json.Marshal(&hostReply{Type: "frida:host", ID: call.ID, Error: &msg})
+	}
+	if err := scr.Post(string(b), nil); err != nil {
+		log.WithFields(logrus.Fields{
+			"name": call.Name,
+			"err":  err,
+		}).Error("Script: host call reply failed")
+	}
+}
+
+// inherit shares the message handlers of old and copies its log handler
+// and exports, so that a replacement script keeps serving the same
+// subscribers. old keeps running, and keeps owning the handlers, until the
+// replacement takes over.
+func (scr *Script) inherit(old *Script) {
+	old.mu.Lock()
+	hs := old.handlers
+	logHandler := old.logHandler
+	errHandler := old.errHandler
+	exports := make(map[string]ExportFunc, len(old.exports))
+	for name, fn := range old.exports {
+		exports[name] = fn
+	}
+	old.mu.Unlock()
+
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	own := scr.handlers.list()
+	hs.mu.Lock()
+	hs.handlers = append(hs.handlers, own...)
+	hs.mu.Unlock()
+	scr.handlers = hs
+	scr.logHandler = logHandler
+	scr.errHandler = errHandler
+	for name, fn := range exports {
+		scr.exports[name] = fn
+	}
+}
+
+// takeOver makes scr responsible for closing the handlers it inherited,
+// once it has replaced the script it inherited them from.
+func (scr *Script) takeOver() {
+	hs := scr.handlerSet()
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.owner = scr
+}
+
+// UnLoad unloads the script and releases it. The Go side is torn down even
+// when unloading fails, as it does once the target is gone.
+func (scr *Script) UnLoad() (err error) {
+	if scr.replay {
+		scr.teardown()
+		scr.closeHandlers()
+		return nil
+	}
+	if scr.ptr == nil {
+		return nil
+	}
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
 	C.frida_script_unload_sync(scr.ptr, cancel, &gerr)
 	if gerr != nil {
-		return NewErrorFromGError(gerr)
+		err = NewErrorFromGError(gerr)
 	}
+	C.g_signal_handler_disconnect(C.gpointer(scr.ptr), scr.handlerID)
+	C.g_signal_handler_disconnect(C.gpointer(scr.ptr), scr.destroyedID)
+	scr.teardown()
 	C.frida_unref(C.gpointer(scr.ptr))
 	scr.ptr = nil
-	return nil
+	return
 }
 
+// Load starts the script. It is a no-op on a replay script.
 func (scr *Script) Load() error {
+	if scr.replay {
+		return nil
+	}
+	if scr.ptr == nil {
+		return ErrInvalidOperation
+	}
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
 	C.frida_script_load_sync(scr.ptr, cancel, &gerr)
@@ -163,7 +668,15 @@ func (scr *Script) Load() error {
 	return nil
 }
 
+// Eternalize keeps the script running after it is released. It is a no-op
+// on a replay script.
 func (scr *Script) Eternalize() error {
+	if scr.replay {
+		return nil
+	}
+	if scr.ptr == nil {
+		return ErrInvalidOperation
+	}
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
 	C.frida_script_eternalize_sync(scr.ptr, cancel, &gerr)
@@ -173,24 +686,66 @@ func (scr *Script) Eternalize() error {
 	return nil
 }
 
+// Post sends a raw JSON message, with optional binary data, to the agent.
 func (scr *Script) Post(message string, data []byte) (err error) {
-	var gerr *C.GError
-	if gData, ok := GoBytesToGBytes(data); ok {
-		cancel := C.g_cancellable_new()
-		C.frida_script_post_sync(scr.ptr, C.CString(message), gData, cancel, &gerr)
+	if scr.replay {
+		recordMessage(RecordOut, scr, message, data)
+		return
+	}
+	if scr.ptr == nil {
+		return ErrInvalidOperation
+	}
+	var gData *C.GBytes
+	if len(data) > 0 {
+		var ok bool
+		if gData, ok = GoBytesToGBytes(data); !ok {
+			return NewErrorAndLog("Script: post data conversion failed")
+		}
+		defer C.g_bytes_unref(gData)
 	}
+
+	cMessage := C.CString(message)
+	defer C.free(unsafe.Pointer(cMessage))
+
+	var gerr *C.GError
+	cancel := C.g_cancellable_new()
+	defer C.g_object_unref(C.gpointer(cancel))
+	C.frida_script_post_sync(scr.ptr, cMessage, gData, cancel, &gerr)
 	if gerr != nil {
 		err = NewErrorFromGError(gerr)
+		return
 	}
-	return nil
+	recordMessage(RecordOut, scr, message, data)
+	return
 }
 
+// PostJSON marshals v and posts it to the agent.
+func (scr *Script) PostJSON(v interface{}, data []byte) (err error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	return scr.Post(string(b), data)
+}
+
+// Send posts {type, payload}, to be picked up by recv(type, cb) in the agent.
+func (scr *Script) Send(typ string, payload interface{}) error {
+	return scr.PostJSON(map[string]interface{}{
+		"type":    typ,
+		"payload": payload,
+	}, nil)
+}
+
+// RpcCall calls an export of the agent's rpc.exports. A replay script has
+// no agent to answer, so it fails with ErrNotSupported.
 func (scr *Script) RpcCall(js_name string, args ...string) (result interface{}, err error) {
+	if scr.replay {
+		return nil, ErrNotSupported
+	}
 	reqID := fmt.Sprintf("%s_%d", "req", atomic.AddUint64(&reqIDNum, 1))
-	key := fmt.Sprintf("%d_%s", scr.ID, reqID)
 	cb := make(chan *rpcResult, 1)
-	defer close(cb)
-	cbs.Store(key, cb)
+	scr.rpcCalls.Store(reqID, cb)
+	defer scr.rpcCalls.Delete(reqID)
 
 	request := []string{"frida:rpc", reqID, "call", js_name}
 	for i, _ := range args {
@@ -200,7 +755,7 @@ func (scr *Script) RpcCall(js_name string, args ...string) (result interface{},
 	if err != nil {
 		return
 	}
-	err = scr.Post(string(b), make([]byte, 0))
+	err = scr.Post(string(b), nil)
 	if err != nil {
 		return
 	}
@@ -252,7 +807,22 @@ func NewScript(sess *Session, name string, source interface{}, runtime C.FridaSc
 			ptr:  script,
 			ID:   uint(C.frida_script_get_id(script)),
 			Name: name,
+			Pid:  sess.Pid,
+
+			handle:     uint(atomic.AddUint64(&scriptIDNum, 1)),
+			queue:      newEventQueue[*rawMessage](),
+			dispatched: make(chan struct{}),
+			exports:    make(map[string]ExportFunc),
 		}
+		s.handlers = newHandlerSet(s)
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		scripts.Store(s.handle, s)
+		go s.msgDispatch()
+		s.handlerID = C._connect_message(script, C.guint(s.handle))
+		cSig := C.CString("destroyed")
+		defer C.free(unsafe.Pointer(cSig))
+		s.destroyedID = C._connect_signal(C.gpointer(script), cSig,
+			C.GCallback(C._on_script_destroyed), C.guint(s.handle))
 	}
 	return
 }
@@ -0,0 +1,203 @@
+package fridago
+
+/*
+ #include "frida-core.h"
+*/
+import "C"
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type ScriptFileOptions struct {
+	Name string
+	// Runtime defaults to V8, as for CreateScriptFromFileSync.
+	Runtime  uint
+	Interval time.Duration
+	Bundle   *BundleOptions
+	// OnReload is called after every reload attempt, with the new script on
+	// success or the error that kept the old one running.
+	OnReload func(s *Script, err error)
+}
+
+// ScriptFile is a script bundled from a source file and reloaded whenever
+// the file, or a file it imports, changes on disk.
+type ScriptFile struct {
+	Path string
+
+	sess *Session
+	opts ScriptFileOptions
+
+	mu     sync.Mutex
+	script *Script
+	mtimes map[string]time.Time
+	done   chan struct{}
+	closed sync.Once
+	wg     sync.WaitGroup
+}
+
+func (sf *ScriptFile) Script() *Script {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.script
+}
+
+// Reload re-creates the script from the file. The running script is only
+// unloaded once its replacement has loaded.
+func (sf *ScriptFile) Reload() (err error) {
+	s, err := sf.reload()
+	// called unlocked, so the callback may use the ScriptFile
+	if sf.opts.OnReload != nil {
+		sf.opts.OnReload(s, err)
+	}
+	return
+}
+
+func (sf *ScriptFile) reload() (s *Script, err error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	b, err := BundleFile(sf.Path, sf.opts.Bundle)
+	if err == nil {
+		sf.mtimes = statFiles(b.Files)
+		err = sf.replace(b)
+	} else {
+		// keep watching what we had, so fixing the file triggers a retry
+		files := []string{sf.Path}
+		for f := range sf.mtimes {
+			files = append(files, f)
+		}
+		sf.mtimes = statFiles(files)
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path": sf.Path,
+			"err":  err,
+		}).Error("ScriptFile: reload failed")
+	}
+	return sf.script, err
+}
+
+func (sf *ScriptFile) replace(b *Bundle) (err error) {
+	sm, err := ParseSourceMap(b.SourceMap)
+	if err != nil {
+		return
+	}
+	s, err := NewScript(sf.sess, sf.opts.Name, b.Source, C.FridaScriptRuntime(sf.opts.Runtime))
+	if err != nil {
+		return
+	}
+	if s == nil {
+		return NewErrorAndLog("ScriptFile: create script failed")
+	}
+	s.SetSourceMap(sm)
+	old := sf.script
+	if old != nil {
+		s.inherit(old)
+	}
+	if err = s.Load(); err != nil {
+		s.UnLoad()
+		return
+	}
+	s.takeOver()
+	sf.script = s
+	if old != nil {
+		old.UnLoad()
+	}
+	return
+}
+
+func (sf *ScriptFile) changed() bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for path, mtime := range sf.mtimes {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if !mtime.IsZero() {
+				return true
+			}
+		} else if !fi.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sf *ScriptFile) watch() {
+	defer sf.wg.Done()
+	ticker := time.NewTicker(sf.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sf.done:
+			return
+		case <-ticker.C:
+			if sf.changed() {
+				log.WithFields(logrus.Fields{
+					"path": sf.Path,
+				}).Info("ScriptFile: change detected, reloading")
+				sf.Reload()
+			}
+		}
+	}
+}
+
+// Close stops watching and unloads the current script. Closing twice is a
+// no-op.
+func (sf *ScriptFile) Close() (err error) {
+	sf.closed.Do(func() { close(sf.done) })
+	sf.wg.Wait()
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.script != nil {
+		err = sf.script.UnLoad()
+		sf.script = nil
+	}
+	return
+}
+
+func statFiles(files []string) map[string]time.Time {
+	mtimes := make(map[string]time.Time, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			mtimes[f] = fi.ModTime()
+		} else {
+			mtimes[f] = time.Time{}
+		}
+	}
+	return mtimes
+}
+
+// LoadScriptFile creates and loads a script from path, then keeps it in
+// sync with the file until the returned ScriptFile is closed.
+func (sess *Session) LoadScriptFile(path string, opts *ScriptFileOptions) (sf *ScriptFile, err error) {
+	sf = &ScriptFile{
+		Path: path,
+		sess: sess,
+		done: make(chan struct{}),
+	}
+	if opts != nil {
+		sf.opts = *opts
+	}
+	if sf.opts.Name == "" {
+		sf.opts.Name = filepath.Base(path)
+	}
+	if sf.opts.Runtime == SCRIPT_RUNTIME_DEFAULT {
+		sf.opts.Runtime = SCRIPT_RUNTIME_V8
+	}
+	if sf.opts.Interval <= 0 {
+		sf.opts.Interval = 500 * time.Millisecond
+	}
+
+	if err = sf.Reload(); err != nil {
+		return nil, err
+	}
+	sf.wg.Add(1)
+	go sf.watch()
+	return
+}
@@ -5,6 +5,8 @@ package fridago
 */
 import "C"
 import (
+	"sync"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -12,6 +14,9 @@ type Session struct {
 	ptr *C.FridaSession
 	Dev *Device
 	Pid uint
+
+	agentMu sync.Mutex
+	agent   *Script
 }
 
 func (sess *Session) IsDetached() bool {
@@ -36,7 +41,43 @@ func (sess *Session) CreateScriptFromBytesSync(name string, bytes []byte, runtim
 	return NewScript(sess, name, bytes, C.FRIDA_SCRIPT_RUNTIME_DUK)
 }
 
+// CreateScriptFromFileSync bundles the agent at entry with its imports and
+// creates a script from the result.
+func (sess *Session) CreateScriptFromFileSync(name string, entry string, opts *BundleOptions) (s *Script, err error) {
+	log.WithFields(logrus.Fields{
+		"name":  name,
+		"entry": entry,
+	}).Debug("Session: create script from file ...")
+
+	b, err := BundleFile(entry, opts)
+	if err != nil {
+		return
+	}
+	return sess.CreateScriptWithSourceMapSync(name, b.Source, b.SourceMap)
+}
+
+// CreateScriptWithSourceMapSync creates a script whose error positions and
+// stack traces are mapped back through sourceMap.
+func (sess *Session) CreateScriptWithSourceMapSync(name string, source string, sourceMap []byte, runtime ...uint) (s *Script, err error) {
+	sm, err := ParseSourceMap(sourceMap)
+	if err != nil {
+		return
+	}
+	s, err = sess.CreateScriptSync(name, source, runtime...)
+	if err == nil && s != nil {
+		s.SetSourceMap(sm)
+	}
+	return
+}
+
 func (sess *Session) Detach() (err error) {
+	sess.agentMu.Lock()
+	if sess.agent != nil {
+		sess.agent.UnLoad()
+		sess.agent = nil
+	}
+	sess.agentMu.Unlock()
+
 	var gerr *C.GError
 	cancel := C.g_cancellable_new()
 	C.frida_session_detach_sync(sess.ptr, cancel, &gerr)
@@ -0,0 +1,282 @@
+package fridago
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChildPolicy decides which children FollowChildren instruments.
+type ChildPolicy struct {
+	// Origins restricts following to "fork", "exec" and/or "spawn"
+	// children; empty means all of them.
+	Origins []string
+	Match   func(c *Child) bool
+	// Setup runs once a child is attached, before it is resumed, and
+	// typically loads the node's scripts.
+	Setup func(n *SessionNode) error
+	// PollInterval is how often sessions are checked for detachment.
+	PollInterval time.Duration
+}
+
+func (p *ChildPolicy) follows(c *Child) bool {
+	if len(p.Origins) > 0 {
+		found := false
+		for _, o := range p.Origins {
+			if o == c.ChildOrigin {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return p.Match == nil || p.Match(c)
+}
+
+// SessionNode is one instrumented process in a SessionTree. Child is nil
+// for the root.
+type SessionNode struct {
+	Pid     uint
+	Session *Session
+	Child   *Child
+	Parent  *SessionNode
+
+	mu       sync.Mutex
+	children []*SessionNode
+}
+
+func (n *SessionNode) Children() []*SessionNode {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*SessionNode{}, n.children...)
+}
+
+func (n *SessionNode) addChild(c *SessionNode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.children = append(n.children, c)
+}
+
+func (n *SessionNode) removeChild(c *SessionNode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, cc := range n.children {
+		if cc == c {
+			n.children = append(n.children[:i:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// SessionTree follows the children of a session, attaching to each one
+// the policy accepts, recursively.
+type SessionTree struct {
+	Root *SessionNode
+
+	policy   ChildPolicy
+	dev      *Device
+	children chan *Child
+	done     chan struct{}
+	closed   sync.Once
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	nodes map[uint]*SessionNode
+}
+
+// FollowChildren enables child gating on the session and instruments its
+// descendants according to policy until the tree is closed. Children the
+// policy rejects are resumed untouched.
+func (sess *Session) FollowChildren(policy ChildPolicy) (t *SessionTree, err error) {
+	if policy.PollInterval <= 0 {
+		policy.PollInterval = time.Second
+	}
+	root := &SessionNode{Pid: sess.Pid, Session: sess}
+	t = &SessionTree{
+		Root:     root,
+		policy:   policy,
+		dev:      sess.Dev,
+		children: make(chan *Child, 16),
+		done:     make(chan struct{}),
+		nodes:    map[uint]*SessionNode{sess.Pid: root},
+	}
+	if err = t.dev.On("child-added", t.children); err != nil {
+		return nil, err
+	}
+	if err = sess.EnableChildGating(); err != nil {
+		t.dev.Off("child-added", t.children)
+		return nil, err
+	}
+	t.wg.Add(1)
+	go t.run()
+	return
+}
+
+// Nodes returns every process currently in the tree.
+func (t *SessionTree) Nodes() []*SessionNode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	nodes := make([]*SessionNode, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func (t *SessionTree) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.policy.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case c := <-t.children:
+			t.handleChild(c)
+		case <-ticker.C:
+			t.prune()
+		}
+	}
+}
+
+func (t *SessionTree) handleChild(c *Child) {
+	t.mu.Lock()
+	parent, ok := t.nodes[c.ParentPid]
+	t.mu.Unlock()
+	if !ok {
+		// gated by a session outside this tree
+		return
+	}
+
+	if t.policy.follows(c) {
+		if err := t.attach(parent, c); err != nil {
+			log.WithFields(logrus.Fields{
+				"pid":    c.Pid,
+				"origin": c.ChildOrigin,
+				"err":    err,
+			}).Error("SessionTree: follow child failed")
+		}
+	}
+	if err := t.dev.Resume(c.Pid); err != nil {
+		log.WithFields(logrus.Fields{
+			"pid": c.Pid,
+			"err": err,
+		}).Error("SessionTree: resume child failed")
+	}
+}
+
+func (t *SessionTree) attach(parent *SessionNode, c *Child) (err error) {
+	if c.ChildOrigin == "exec" && parent.Pid == c.Pid {
+		// exec replaced the parent's image; its node now stands for the
+		// new program, so hang the new session off the grandparent
+		t.remove(parent)
+		if parent.Parent != nil {
+			parent = parent.Parent
+		}
+	}
+	sess, err := t.dev.Attach(c.Pid)
+	if err != nil {
+		return
+	}
+	n := &SessionNode{
+		Pid:     c.Pid,
+		Session: sess,
+		Child:   c,
+		Parent:  parent,
+	}
+	if err = sess.EnableChildGating(); err != nil {
+		sess.Detach()
+		return
+	}
+	if t.policy.Setup != nil {
+		if err = t.policy.Setup(n); err != nil {
+			sess.Detach()
+			return
+		}
+	}
+	parent.addChild(n)
+	t.mu.Lock()
+	t.nodes[n.Pid] = n
+	t.mu.Unlock()
+	return
+}
+
+// prune drops the nodes whose process has gone away.
+func (t *SessionTree) prune() {
+	for _, n := range t.Nodes() {
+		if n != t.Root && n.Session.IsDetached() {
+			t.remove(n)
+		}
+	}
+}
+
+func (t *SessionTree) remove(n *SessionNode) {
+	t.mu.Lock()
+	if t.nodes[n.Pid] == n {
+		delete(t.nodes, n.Pid)
+	}
+	t.mu.Unlock()
+	if n.Parent != nil {
+		n.Parent.removeChild(n)
+		// orphans move up to the nearest live ancestor
+		for _, c := range n.Children() {
+			c.Parent = n.Parent
+			n.Parent.addChild(c)
+		}
+	}
+}
+
+// Close stops following children, resumes those still held by the tree's
+// sessions and detaches every session in the tree but the root. Closing
+// twice is a no-op.
+func (t *SessionTree) Close() (err error) {
+	t.closed.Do(func() {
+		err = t.close()
+	})
+	return
+}
+
+func (t *SessionTree) close() (err error) {
+	t.dev.Off("child-added", t.children)
+	close(t.done)
+	t.wg.Wait()
+
+	nodes := t.Nodes()
+	ours := make(map[uint]bool, len(nodes))
+	for _, n := range nodes {
+		ours[n.Pid] = true
+		if n.Session.IsDetached() {
+			continue
+		}
+		if gerr := n.Session.DisableChildGating(); gerr != nil && n == t.Root {
+			err = gerr
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case c := <-t.children:
+			if ours[c.ParentPid] {
+				t.dev.Resume(c.Pid)
+			}
+		default:
+			drained = true
+		}
+	}
+	if pending, perr := t.dev.EnumeratePendingChildrenSync(); perr == nil {
+		for _, c := range pending {
+			if ours[c.ParentPid] {
+				t.dev.Resume(c.Pid)
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		if n != t.Root && !n.Session.IsDetached() {
+			n.Session.Detach()
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package fridago
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reFrame matches the "file:line:col" location of a stack frame, in the
+// "at fn (file:l:c)", "at file:l:c" and "fn@file:l:c" forms.
+var reFrame = regexp.MustCompile(`(\bat\s+(?:[^\n()]*\()?|@)([^\s()@]+):(\d+):(\d+)`)
+
+type sourceMapSegment struct {
+	genCol   int
+	src      int
+	origLine int
+	origCol  int
+}
+
+// SourceMap maps generated script positions back to original sources.
+type SourceMap struct {
+	Sources []string
+	lines   [][]sourceMapSegment
+}
+
+func ParseSourceMap(raw []byte) (sm *SourceMap, err error) {
+	var v struct {
+		Version    int      `json:"version"`
+		SourceRoot string   `json:"sourceRoot"`
+		Sources    []string `json:"sources"`
+		Mappings   string   `json:"mappings"`
+	}
+	if err = json.Unmarshal(raw, &v); err != nil {
+		return
+	}
+	if v.Version != 3 {
+		return nil, fmt.Errorf("SourceMap: unsupported version %d", v.Version)
+	}
+
+	sm = &SourceMap{}
+	for _, s := range v.Sources {
+		sm.Sources = append(sm.Sources, v.SourceRoot+s)
+	}
+	var src, origLine, origCol int
+	for _, line := range strings.Split(v.Mappings, ";") {
+		var (
+			segs   []sourceMapSegment
+			genCol int
+		)
+		for _, field := range strings.Split(line, ",") {
+			if field == "" {
+				continue
+			}
+			vals, err := decodeVLQ(field)
+			if err != nil {
+				return nil, err
+			}
+			genCol += vals[0]
+			if len(vals) < 4 {
+				continue
+			}
+			src += vals[1]
+			origLine += vals[2]
+			origCol += vals[3]
+			segs = append(segs, sourceMapSegment{genCol, src, origLine, origCol})
+		}
+		sort.Slice(segs, func(i, j int) bool { return segs[i].genCol < segs[j].genCol })
+		sm.lines = append(sm.lines, segs)
+	}
+	return
+}
+
+// Lookup maps a 1-based generated line and column to the original source
+// and its 1-based line and column.
+func (sm *SourceMap) Lookup(line, col int) (source string, origLine, origCol int, ok bool) {
+	if line < 1 || line > len(sm.lines) || len(sm.lines[line-1]) == 0 {
+		return
+	}
+	segs := sm.lines[line-1]
+	i := sort.Search(len(segs), func(i int) bool { return segs[i].genCol > col-1 }) - 1
+	if i < 0 {
+		i = 0
+	}
+	seg := segs[i]
+	if seg.src < 0 || seg.src >= len(sm.Sources) {
+		return
+	}
+	delta := col - 1 - seg.genCol
+	if delta < 0 {
+		delta = 0
+	}
+	return sm.Sources[seg.src], seg.origLine + 1, seg.origCol + delta + 1, true
+}
+
+// Rewrite maps the stack frames in text that point into file, the
+// generated script, back to their original positions. Other text is left
+// alone.
+func (sm *SourceMap) Rewrite(text, file string) string {
+	return reFrame.ReplaceAllStringFunc(text, func(frame string) string {
+		m := reFrame.FindStringSubmatch(frame)
+		if m[2] != file {
+			return frame
+		}
+		line, _ := strconv.Atoi(m[3])
+		col, _ := strconv.Atoi(m[4])
+		source, origLine, origCol, ok := sm.Lookup(line, col)
+		if !ok {
+			return frame
+		}
+		return fmt.Sprintf("%s%s:%d:%d", m[1], source, origLine, origCol)
+	})
+}
+
+func decodeVLQ(field string) (vals []int, err error) {
+	var value, shift int
+	for i := 0; i < len(field); i++ {
+		digit := strings.IndexByte(base64VLQ, field[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("SourceMap: invalid mapping character %q", field[i])
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			vals = append(vals, -(value >> 1))
+		} else {
+			vals = append(vals, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	return
+}
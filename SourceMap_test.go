@@ -0,0 +1,141 @@
+package fridago
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVLQ(t *testing.T) {
+	tests := []struct {
+		v   int
+		enc string
+	}{
+		{0, "A"},
+		{1, "C"},
+		{-1, "D"},
+		{15, "e"},
+		{16, "gB"},
+		{-16, "hB"},
+		{123456, "gkxH"},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		writeVLQ(&sb, tt.v)
+		if got := sb.String(); got != tt.enc {
+			t.Errorf("writeVLQ(%d) = %q, want %q", tt.v, got, tt.enc)
+		}
+		vals, err := decodeVLQ(tt.enc)
+		if err != nil || len(vals) != 1 || vals[0] != tt.v {
+			t.Errorf("decodeVLQ(%q) = %v, %v, want [%d]", tt.enc, vals, err, tt.v)
+		}
+	}
+
+	vals, err := decodeVLQ("AACgBD")
+	if want := []int{0, 0, 1, 16, -1}; err != nil || !reflect.DeepEqual(vals, want) {
+		t.Errorf("decodeVLQ(AACgBD) = %v, %v, want %v", vals, err, want)
+	}
+	if _, err := decodeVLQ("A!"); err == nil {
+		t.Error("decodeVLQ accepted an invalid character")
+	}
+}
+
+func TestSourceMapWriter(t *testing.T) {
+	var w sourceMapWriter
+	w.mapLine(1, 0, 0)
+	w.mapLine(2, 0, 1)
+	w.mapLine(4, 1, 0)
+	sm, err := ParseSourceMap([]byte(`{"version":3,"sources":["a.js","b.js"],"mappings":"` + w.String() + `"}`))
+	if err != nil {
+		t.Fatalf("ParseSourceMap: %v", err)
+	}
+	tests := []struct {
+		line   int
+		source string
+		orig   int
+		ok     bool
+	}{
+		{1, "", 0, false},
+		{2, "a.js", 1, true},
+		{3, "a.js", 2, true},
+		{4, "", 0, false},
+		{5, "b.js", 1, true},
+		{6, "", 0, false},
+	}
+	for _, tt := range tests {
+		source, orig, _, ok := sm.Lookup(tt.line, 1)
+		if source != tt.source || orig != tt.orig || ok != tt.ok {
+			t.Errorf("Lookup(%d) = %q, %d, %v, want %q, %d, %v", tt.line, source, orig, ok, tt.source, tt.orig, tt.ok)
+		}
+	}
+}
+
+func TestParseSourceMap(t *testing.T) {
+	if _, err := ParseSourceMap([]byte(`{"version":2,"sources":[],"mappings":""}`)); err == nil {
+		t.Error("accepted version 2")
+	}
+	if _, err := ParseSourceMap([]byte(`{`)); err == nil {
+		t.Error("accepted invalid JSON")
+	}
+	sm, err := ParseSourceMap([]byte(`{"version":3,"sourceRoot":"src/","sources":["a.js"],"mappings":""}`))
+	if err != nil || !reflect.DeepEqual(sm.Sources, []string{"src/a.js"}) {
+		t.Errorf("Sources = %v, %v, want [src/a.js]", sm, err)
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	// line 1: col 0 -> a.js 1:1, col 10 -> a.js 1:5
+	// line 2: col 4 -> b.js 3:1
+	sm, err := ParseSourceMap([]byte(`{"version":3,"sources":["a.js","b.js"],"mappings":"AAAA,UAAI;ICEJ"}`))
+	if err != nil {
+		t.Fatalf("ParseSourceMap: %v", err)
+	}
+	tests := []struct {
+		line, col int
+		source    string
+		oline     int
+		ocol      int
+		ok        bool
+	}{
+		{1, 1, "a.js", 1, 1, true},
+		{1, 3, "a.js", 1, 3, true},
+		{1, 11, "a.js", 1, 5, true},
+		{1, 13, "a.js", 1, 7, true},
+		{2, 1, "b.js", 3, 1, true},
+		{2, 5, "b.js", 3, 1, true},
+		{2, 7, "b.js", 3, 3, true},
+		{0, 1, "", 0, 0, false},
+		{3, 1, "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		source, oline, ocol, ok := sm.Lookup(tt.line, tt.col)
+		if source != tt.source || oline != tt.oline || ocol != tt.ocol || ok != tt.ok {
+			t.Errorf("Lookup(%d, %d) = %q, %d, %d, %v, want %q, %d, %d, %v",
+				tt.line, tt.col, source, oline, ocol, ok, tt.source, tt.oline, tt.ocol, tt.ok)
+		}
+	}
+}
+
+func TestSourceMapRewrite(t *testing.T) {
+	sm, err := ParseSourceMap([]byte(`{"version":3,"sources":["a.js","b.js"],"mappings":"AAAA,UAAI;ICEJ"}`))
+	if err != nil {
+		t.Fatalf("ParseSourceMap: %v", err)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"    at f (/agent.js:1:11)", "    at f (a.js:1:5)"},
+		{"    at /agent.js:2:5", "    at b.js:3:1"},
+		{"f@/agent.js:1:1", "f@a.js:1:1"},
+		{"    at g (/other.js:1:11)", "    at g (/other.js:1:11)"},
+		{"    at h (/agent.js:9:1)", "    at h (/agent.js:9:1)"},
+		{"value 1:2:3 and /agent.js:1:1 in prose", "value 1:2:3 and /agent.js:1:1 in prose"},
+		{"Error: x\n    at f (/agent.js:1:1)\n    at /agent.js:2:7",
+			"Error: x\n    at f (a.js:1:1)\n    at b.js:3:3"},
+	}
+	for _, tt := range tests {
+		if got := sm.Rewrite(tt.in, "/agent.js"); got != tt.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
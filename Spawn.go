@@ -45,9 +45,9 @@ func NewSpawn(fs *C.FridaSpawn) (s *Spawn, err error) {
 //export onSpawnAdded
 func onSpawnAdded(dev *C.FridaDevice, ptr *C.FridaSpawn, userData C.gpointer) {
 	log.Infof("Device: On spawn added")
-	if chSpawnAdd != nil {
+	if d := lookupDevice(userData); d != nil {
 		if s, err := NewSpawn(ptr); err == nil {
-			*chSpawnAdd <- s
+			d.publish("spawn-added", s)
 		}
 	}
 }
@@ -0,0 +1,244 @@
+package fridago
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type SpawnAction int
+
+const (
+	SpawnResume SpawnAction = iota
+	SpawnAttach
+	SpawnKill
+)
+
+func (a SpawnAction) String() string {
+	switch a {
+	case SpawnResume:
+		return "resume"
+	case SpawnAttach:
+		return "attach"
+	case SpawnKill:
+		return "kill"
+	}
+	return "unknown"
+}
+
+// SpawnHandler decides what happens to a gated spawn. ctx is done once the
+// gate's decision timeout expires, after which the answer is ignored.
+type SpawnHandler func(ctx context.Context, s *Spawn) SpawnAction
+
+// SpawnDecision records what a SpawnGate did with a spawn.
+type SpawnDecision struct {
+	Spawn    *Spawn
+	Action   SpawnAction
+	TimedOut bool
+	Session  *Session
+	Err      error
+	Time     time.Time
+}
+
+// SpawnGate enables spawn gating on a device and makes sure every spawn it
+// catches is decided on: by the handler, by the default action once the
+// timeout expires, or by resuming it when the gate shuts down.
+type SpawnGate struct {
+	Device  *Device
+	Handler SpawnHandler
+	// Timeout bounds each decision; zero waits for the handler forever.
+	Timeout time.Duration
+	Default SpawnAction
+	// OnAttach runs for SpawnAttach decisions before the spawn is resumed,
+	// typically to load scripts into the session.
+	OnAttach func(s *Spawn, sess *Session) error
+
+	mu        sync.Mutex
+	pending   map[uint]*Spawn
+	decisions []*SpawnDecision
+	wg        sync.WaitGroup
+}
+
+func NewSpawnGate(d *Device, handler SpawnHandler) *SpawnGate {
+	return &SpawnGate{
+		Device:  d,
+		Handler: handler,
+		Timeout: 10 * time.Second,
+		Default: SpawnResume,
+		pending: make(map[uint]*Spawn),
+	}
+}
+
+// Pending returns the spawns still waiting for a decision.
+func (g *SpawnGate) Pending() []*Spawn {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sl := make([]*Spawn, 0, len(g.pending))
+	for _, s := range g.pending {
+		sl = append(sl, s)
+	}
+	return sl
+}
+
+// Decisions returns every decision taken so far, oldest first.
+func (g *SpawnGate) Decisions() []*SpawnDecision {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*SpawnDecision{}, g.decisions...)
+}
+
+// Run gates spawns until ctx is done, then disables gating and resumes any
+// spawn still pending.
+func (g *SpawnGate) Run(ctx context.Context) (err error) {
+	spawns := make(chan *Spawn, 16)
+	if err = g.Device.On("spawn-added", spawns); err != nil {
+		return
+	}
+	if err = g.Device.EnableSpawnGating(); err != nil {
+		g.Device.Off("spawn-added", spawns)
+		return
+	}
+	defer g.shutdown(spawns)
+
+	pending, _ := g.Device.EnumeratePendingSpawnSync()
+	for _, s := range pending {
+		g.queue(ctx, s)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-spawns:
+			g.queue(ctx, s)
+		}
+	}
+}
+
+func (g *SpawnGate) queue(ctx context.Context, s *Spawn) {
+	g.mu.Lock()
+	if _, seen := g.pending[s.Pid]; seen {
+		g.mu.Unlock()
+		return
+	}
+	g.pending[s.Pid] = s
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.decide(ctx, s)
+	}()
+}
+
+func (g *SpawnGate) decide(ctx context.Context, s *Spawn) {
+	dctx := ctx
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		dctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	answer := make(chan SpawnAction, 1)
+	go func() {
+		if g.Handler == nil {
+			answer <- g.Default
+			return
+		}
+		answer <- g.Handler(dctx, s)
+	}()
+
+	d := &SpawnDecision{Spawn: s}
+	select {
+	case d.Action = <-answer:
+	case <-dctx.Done():
+		d.Action = g.Default
+		d.TimedOut = ctx.Err() == nil
+		if !d.TimedOut {
+			// shutting down: never leave the spawn suspended
+			d.Action = SpawnResume
+		}
+	}
+	g.apply(d)
+}
+
+func (g *SpawnGate) apply(d *SpawnDecision) {
+	pid := d.Spawn.Pid
+	switch d.Action {
+	case SpawnKill:
+		d.Err = g.Device.Kill(pid)
+	case SpawnAttach:
+		d.Session, d.Err = g.Device.Attach(pid)
+		if d.Err == nil && g.OnAttach != nil {
+			d.Err = g.OnAttach(d.Spawn, d.Session)
+		}
+		if err := g.Device.Resume(pid); d.Err == nil {
+			d.Err = err
+		}
+	default:
+		d.Err = g.Device.Resume(pid)
+	}
+	g.record(d)
+}
+
+func (g *SpawnGate) record(d *SpawnDecision) {
+	d.Time = time.Now()
+	log.WithFields(logrus.Fields{
+		"pid":        d.Spawn.Pid,
+		"identifier": d.Spawn.Identifier,
+		"action":     d.Action.String(),
+		"timed_out":  d.TimedOut,
+		"err":        d.Err,
+	}).Debug("SpawnGate: decided")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.pending, d.Spawn.Pid)
+	g.decisions = append(g.decisions, d)
+}
+
+// shutdown leaves nothing suspended: spawns still queued on the channel,
+// those decide is working on and those frida caught after we stopped
+// listening are all resumed.
+func (g *SpawnGate) shutdown(spawns chan *Spawn) {
+	g.Device.Off("spawn-added", spawns)
+	for drained := false; !drained; {
+		select {
+		case s := <-spawns:
+			g.mu.Lock()
+			_, deciding := g.pending[s.Pid]
+			g.mu.Unlock()
+			if !deciding {
+				g.resume(s)
+			}
+		default:
+			drained = true
+		}
+	}
+	if err := g.Device.DisableSpawnGating(); err != nil {
+		log.WithField("err", err).Error("SpawnGate: disable spawn gating failed")
+	}
+	// decide resumes on cancellation
+	g.wg.Wait()
+
+	pending, err := g.Device.EnumeratePendingSpawnSync()
+	if err != nil {
+		log.WithField("err", err).Error("SpawnGate: enumerate pending spawns failed")
+	}
+	for _, s := range pending {
+		g.resume(s)
+	}
+	// stragglers decide never got to
+	for _, s := range g.Pending() {
+		g.resume(s)
+	}
+}
+
+func (g *SpawnGate) resume(s *Spawn) {
+	g.record(&SpawnDecision{
+		Spawn:  s,
+		Action: SpawnResume,
+		Err:    g.Device.Resume(s.Pid),
+	})
+}
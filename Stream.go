@@ -0,0 +1,471 @@
+package fridago
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StreamSource is the agent-side helper for Script.AcceptStream and
+// Script.OpenUploadStream. Prepend it to the agent source to get the
+// globals `stream` and `upload`:
+//
+//	const s = stream.open('dump', { base: '0x1000' });
+//	await s.writeMemory(ptr('0x1000'), size);
+//	await s.end();
+//
+//	const buf = await upload.accept('blob');
+//	await upload.accept('code', { address: ptr('0x2000') });
+//
+//go:embed js/stream.js
+var StreamSource string
+
+// streamWindow is the number of unacknowledged chunks an agent may have in
+// flight; it must match WINDOW in js/stream.js.
+const streamWindow = 8
+
+var (
+	ErrStreamChecksum = errors.New("Stream: checksum mismatch")
+	ErrStreamAborted  = errors.New("Stream: aborted by agent")
+)
+
+type streamChunk struct {
+	seq  uint64
+	crc  uint32
+	data []byte
+	end  bool
+	size uint64
+	err  error
+}
+
+// agentStream is the host end of a stream opened by the agent.
+type agentStream struct {
+	scr      *Script
+	id       uint64
+	label    string
+	metadata json.RawMessage
+
+	chunks chan *streamChunk
+	buf    []byte
+	crc    uint32
+	size   uint64
+	err    error
+
+	mu       sync.Mutex
+	closed   bool
+	overflow bool
+}
+
+type streamHub struct {
+	mu      sync.Mutex
+	accept  map[string]chan *agentStream
+	active  map[uint64]*agentStream
+	uploads map[uint64]*uploadStream
+}
+
+func (scr *Script) streamHub() *streamHub {
+	scr.mu.Lock()
+	defer scr.mu.Unlock()
+	if scr.streams == nil {
+		scr.streams = &streamHub{
+			accept:  make(map[string]chan *agentStream),
+			active:  make(map[uint64]*agentStream),
+			uploads: make(map[uint64]*uploadStream),
+		}
+	}
+	return scr.streams
+}
+
+func (h *streamHub) acceptChan(label string) chan *agentStream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch, ok := h.accept[label]
+	if !ok {
+		ch = make(chan *agentStream, 16)
+		h.accept[label] = ch
+	}
+	return ch
+}
+
+// AcceptStream waits for the agent to open a stream named label and
+// returns its data as a reader, along with the metadata passed to
+// stream.open. Streams opened before AcceptStream is called are queued.
+func (scr *Script) AcceptStream(label string) (r io.ReadCloser, metadata json.RawMessage, err error) {
+	ch := scr.streamHub().acceptChan(label)
+	select {
+	case s := <-ch:
+		return s, s.metadata, nil
+	case <-scr.dispatched:
+		// the script is gone, but streams it opened are still delivered
+		select {
+		case s := <-ch:
+			return s, s.metadata, nil
+		default:
+			return nil, nil, ErrInvalidOperation
+		}
+	}
+}
+
+func (scr *Script) handleStream(rawMsg *rawMessage) {
+	var envelope struct {
+		Payload []json.RawMessage `json:"payload"`
+	}
+	var (
+		op string
+		id uint64
+	)
+	if json.Unmarshal([]byte(rawMsg.msg), &envelope) != nil || len(envelope.Payload) < 3 ||
+		json.Unmarshal(envelope.Payload[1], &op) != nil ||
+		json.Unmarshal(envelope.Payload[2], &id) != nil {
+		log.WithFields(logrus.Fields{
+			"script": scr.Name,
+		}).Error("Stream: bad message")
+		return
+	}
+	args := envelope.Payload[3:]
+	h := scr.streamHub()
+
+	if op == "open" {
+		s := &agentStream{
+			scr:    scr,
+			id:     id,
+			chunks: make(chan *streamChunk, streamWindow+2),
+		}
+		if len(args) > 1 {
+			json.Unmarshal(args[0], &s.label)
+			s.metadata = args[1]
+		}
+		h.mu.Lock()
+		h.active[id] = s
+		h.mu.Unlock()
+		select {
+		case h.acceptChan(s.label) <- s:
+		default:
+			log.WithFields(logrus.Fields{
+				"script": scr.Name,
+				"label":  s.label,
+			}).Error("Stream: too many streams waiting to be accepted")
+			s.Close()
+		}
+		return
+	}
+
+	h.mu.Lock()
+	s := h.active[id]
+	if op == "end" || op == "abort" {
+		delete(h.active, id)
+	}
+	h.mu.Unlock()
+	if s == nil {
+		return
+	}
+
+	c := new(streamChunk)
+	switch op {
+	case "data":
+		if len(args) < 2 {
+			c.err = ErrProtocolError
+			break
+		}
+		json.Unmarshal(args[0], &c.seq)
+		json.Unmarshal(args[1], &c.crc)
+		c.data = rawMsg.data
+	case "end":
+		c.end = true
+		if len(args) < 2 {
+			c.err = ErrProtocolError
+			break
+		}
+		json.Unmarshal(args[0], &c.size)
+		json.Unmarshal(args[1], &c.crc)
+	case "abort":
+		c.err = ErrStreamAborted
+		if len(args) > 0 {
+			var reason string
+			json.Unmarshal(args[0], &reason)
+			c.err = fmt.Errorf("%w: %s", ErrStreamAborted, reason)
+		}
+	default:
+		return
+	}
+	s.deliver(c)
+}
+
+// deliver never blocks the dispatcher: an agent honouring the window
+// cannot overflow the channel, so overflow is treated as a protocol error.
+func (s *agentStream) deliver(c *streamChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.chunks <- c:
+	default:
+		s.overflow = true
+	}
+}
+
+func (s *agentStream) Read(p []byte) (n int, err error) {
+	for len(s.buf) == 0 {
+		s.mu.Lock()
+		if s.overflow && s.err == nil {
+			s.err = ErrProtocolError
+		}
+		s.mu.Unlock()
+		if s.err != nil {
+			return 0, s.err
+		}
+		var c *streamChunk
+		select {
+		case c = <-s.chunks:
+		case <-s.scr.dispatched:
+			// everything the agent sent has been delivered by now
+			select {
+			case c = <-s.chunks:
+			default:
+				s.err = io.ErrUnexpectedEOF
+				continue
+			}
+		}
+		switch {
+		case c.err != nil:
+			s.err = c.err
+		case c.end:
+			if c.size != s.size || c.crc != s.crc {
+				s.err = ErrStreamChecksum
+			} else {
+				s.err = io.EOF
+			}
+		case crc32.ChecksumIEEE(c.data) != c.crc:
+			s.err = ErrStreamChecksum
+		default:
+			s.buf = c.data
+			s.crc = crc32.Update(s.crc, crc32.IEEETable, c.data)
+			s.size += uint64(len(c.data))
+			s.ack(c.seq, false)
+		}
+	}
+	n = copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return
+}
+
+func (s *agentStream) ack(seq uint64, abort bool) {
+	err := s.scr.PostJSON(map[string]interface{}{
+		"type":  "frida:stream:ack",
+		"id":    s.id,
+		"seq":   seq,
+		"abort": abort,
+	}, nil)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"label": s.label,
+			"err":   err,
+		}).Error("Stream: ack failed")
+	}
+}
+
+// Close stops the stream; an agent still writing to it sees its pending
+// writes rejected.
+func (s *agentStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	h := s.scr.streamHub()
+	h.mu.Lock()
+	_, active := h.active[s.id]
+	delete(h.active, s.id)
+	h.mu.Unlock()
+	if active {
+		s.ack(0, true)
+	}
+	return nil
+}
+
+// streamChunkSize matches CHUNK_SIZE in js/stream.js.
+const streamChunkSize = 64 * 1024
+
+var uploadIDNum uint64 = 0
+
+// uploadStream is the host end of a stream written to the agent.
+type uploadStream struct {
+	scr   *Script
+	id    uint64
+	label string
+
+	tokens chan struct{}
+	done   chan error
+	seq    uint64
+	crc    uint32
+	size   uint64
+	err    error
+	ended  bool
+}
+
+// OpenUploadStream returns a writer whose data is delivered, in chunks, to
+// the agent's upload.accept(label, sink); the agent holds an upload opened
+// before it accepts one. At most streamWindow chunks are in flight; Close
+// waits for the agent to confirm the whole upload.
+func (scr *Script) OpenUploadStream(label string) io.WriteCloser {
+	u := &uploadStream{
+		scr:    scr,
+		id:     atomic.AddUint64(&uploadIDNum, 1),
+		label:  label,
+		tokens: make(chan struct{}, streamWindow),
+		done:   make(chan error, 1),
+	}
+	h := scr.streamHub()
+	h.mu.Lock()
+	h.uploads[u.id] = u
+	h.mu.Unlock()
+
+	u.err = u.post("open", nil, map[string]interface{}{"label": label})
+	return u
+}
+
+func (u *uploadStream) post(op string, data []byte, fields map[string]interface{}) error {
+	msg := map[string]interface{}{
+		"type": "frida:upload",
+		"op":   op,
+		"id":   u.id,
+	}
+	for k, v := range fields {
+		msg[k] = v
+	}
+	return u.scr.PostJSON(msg, data)
+}
+
+func (u *uploadStream) finished(err error) {
+	u.ended = true
+	if err == nil {
+		err = ErrStreamAborted
+	}
+	if u.err == nil {
+		u.err = err
+	}
+}
+
+func (u *uploadStream) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if u.err != nil {
+			return n, u.err
+		}
+		select {
+		case u.tokens <- struct{}{}:
+		case err := <-u.done:
+			u.finished(err)
+			continue
+		case <-u.scr.ctx.Done():
+			u.err = io.ErrClosedPipe
+			continue
+		}
+
+		size := len(p)
+		if size > streamChunkSize {
+			size = streamChunkSize
+		}
+		chunk := p[:size]
+		if err = u.post("data", chunk, map[string]interface{}{
+			"seq": u.seq,
+			"crc": crc32.ChecksumIEEE(chunk),
+		}); err != nil {
+			u.err = err
+			return
+		}
+		u.crc = crc32.Update(u.crc, crc32.IEEETable, chunk)
+		u.size += uint64(size)
+		u.seq++
+		n += size
+		p = p[size:]
+	}
+	return
+}
+
+func (u *uploadStream) Close() (err error) {
+	defer func() {
+		h := u.scr.streamHub()
+		h.mu.Lock()
+		delete(h.uploads, u.id)
+		h.mu.Unlock()
+	}()
+
+	if u.err != nil {
+		if !u.ended {
+			u.post("abort", nil, nil)
+		}
+		return u.err
+	}
+	if err = u.post("end", nil, map[string]interface{}{
+		"size": u.size,
+		"crc":  u.crc,
+	}); err != nil {
+		return
+	}
+	select {
+	case err = <-u.done:
+	case <-u.scr.ctx.Done():
+		err = io.ErrClosedPipe
+	}
+	u.ended = true
+	return
+}
+
+func (scr *Script) handleUpload(rawMsg *rawMessage) {
+	var envelope struct {
+		Payload []json.RawMessage `json:"payload"`
+	}
+	var (
+		op string
+		id uint64
+	)
+	if json.Unmarshal([]byte(rawMsg.msg), &envelope) != nil || len(envelope.Payload) < 3 ||
+		json.Unmarshal(envelope.Payload[1], &op) != nil ||
+		json.Unmarshal(envelope.Payload[2], &id) != nil {
+		log.WithFields(logrus.Fields{
+			"script": scr.Name,
+		}).Error("Stream: bad upload message")
+		return
+	}
+
+	h := scr.streamHub()
+	h.mu.Lock()
+	u := h.uploads[id]
+	h.mu.Unlock()
+	if u == nil {
+		return
+	}
+
+	switch op {
+	case "ack":
+		select {
+		case <-u.tokens:
+		default:
+		}
+	case "done":
+		var reason *string
+		if len(envelope.Payload) > 3 {
+			json.Unmarshal(envelope.Payload[3], &reason)
+		}
+		var err error
+		if reason != nil {
+			err = fmt.Errorf("%w: %s", ErrStreamAborted, *reason)
+		}
+		select {
+		case u.done <- err:
+		default:
+		}
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/dsjlzh/fridago
 
-go 1.15
+go 1.18
 
 require github.com/sirupsen/logrus v1.6.0
+
+require golang.org/x/sys v0.0.0-20190422165155-953cdadca894 // indirect
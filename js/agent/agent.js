@@ -0,0 +1,59 @@
+var agent = (function () {
+  var ops = {};
+  var cancelled = {};
+
+  function WithData(result, data) {
+    this.result = result;
+    this.data = data;
+  }
+
+  function reply(id, status, result, data) {
+    send(['frida:agent', id, status, result], data);
+  }
+
+  function onRequest(message, data) {
+    recv('frida:agent', onRequest);
+    var id = message.id;
+    if (message.op === 'cancel') {
+      cancelled[message.args.id] = true;
+      return;
+    }
+    var op = ops[message.op];
+    if (op === undefined) {
+      reply(id, 'error', 'unknown operation ' + message.op);
+      return;
+    }
+    Promise.resolve()
+      .then(function () {
+        return op(message.args || {}, data, id);
+      })
+      .then(function (result) {
+        delete cancelled[id];
+        if (result instanceof WithData)
+          reply(id, 'ok', result.result, result.data);
+        else if (result instanceof ArrayBuffer)
+          reply(id, 'ok', null, result);
+        else
+          reply(id, 'ok', result === undefined ? null : result);
+      }, function (e) {
+        delete cancelled[id];
+        reply(id, 'error', e.message);
+      });
+  }
+  recv('frida:agent', onRequest);
+
+  return {
+    ops: ops,
+    // emit reports progress of a long-running operation.
+    emit: function (id, event, data) {
+      reply(id, 'event', event, data);
+    },
+    cancelled: function (id) {
+      return cancelled[id] === true;
+    },
+    // withData lets an operation reply with both a result and binary data.
+    withData: function (result, data) {
+      return new WithData(result, data);
+    }
+  };
+})();
@@ -0,0 +1,29 @@
+(function () {
+  var allocations = {};
+
+  agent.ops['memory:read'] = function (args) {
+    return ptr(args.address).readByteArray(args.size);
+  };
+
+  agent.ops['memory:write'] = function (args, data) {
+    ptr(args.address).writeByteArray(data);
+  };
+
+  agent.ops['memory:protect'] = function (args) {
+    if (!Memory.protect(ptr(args.address), args.size, args.protection))
+      throw new Error('protect failed');
+  };
+
+  agent.ops['memory:alloc'] = function (args) {
+    var p = Memory.alloc(args.size);
+    allocations[p.toString()] = p;
+    return p.toString();
+  };
+
+  agent.ops['memory:free'] = function (args) {
+    var key = ptr(args.address).toString();
+    if (allocations[key] === undefined)
+      throw new Error('not allocated by Alloc: ' + key);
+    delete allocations[key];
+  };
+})();
@@ -0,0 +1,80 @@
+(function () {
+  function str(p) {
+    return p ? p.toString() : null;
+  }
+
+  function getModule(name) {
+    return Process.getModuleByName(name);
+  }
+
+  function findGlobalExport(name) {
+    if (Module.findGlobalExportByName !== undefined)
+      return Module.findGlobalExportByName(name);
+    return Module.findExportByName(null, name);
+  }
+
+  agent.ops['module:list'] = function () {
+    return Process.enumerateModules().map(function (m) {
+      return { name: m.name, path: m.path, base: str(m.base), size: m.size };
+    });
+  };
+
+  agent.ops['module:exports'] = function (args) {
+    return getModule(args.module).enumerateExports().map(function (e) {
+      return { type: e.type, name: e.name, address: str(e.address) };
+    });
+  };
+
+  agent.ops['module:imports'] = function (args) {
+    return getModule(args.module).enumerateImports().map(function (i) {
+      return {
+        type: i.type || '',
+        name: i.name,
+        module: i.module || '',
+        address: str(i.address),
+        slot: str(i.slot)
+      };
+    });
+  };
+
+  agent.ops['module:symbols'] = function (args) {
+    return getModule(args.module).enumerateSymbols().map(function (s) {
+      return {
+        isGlobal: s.isGlobal,
+        type: s.type,
+        section: s.section ? s.section.id : '',
+        name: s.name,
+        address: str(s.address),
+        size: s.size || 0
+      };
+    });
+  };
+
+  // Names are "symbol" or "module!symbol"; exports win over debug symbols.
+  agent.ops['symbol:resolve'] = function (args) {
+    var name = args.name;
+    var sep = name.indexOf('!');
+    var address = sep >= 0
+      ? getModule(name.substring(0, sep)).findExportByName(name.substring(sep + 1))
+      : findGlobalExport(name);
+    if (address === null && sep < 0) {
+      address = DebugSymbol.fromName(name).address;
+      if (address.isNull())
+        address = null;
+    }
+    if (address === null)
+      throw new Error('symbol not found: ' + name);
+    return str(address);
+  };
+
+  agent.ops['symbol:at'] = function (args) {
+    var s = DebugSymbol.fromAddress(ptr(args.address));
+    return {
+      address: str(s.address),
+      name: s.name || '',
+      moduleName: s.moduleName || '',
+      fileName: s.fileName || '',
+      lineNumber: s.lineNumber || 0
+    };
+  };
+})();
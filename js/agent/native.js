@@ -0,0 +1,59 @@
+(function () {
+  function encode(type, value) {
+    switch (type) {
+      case 'void':
+        return null;
+      case 'double':
+        return value;
+      default:
+        return value.toString();
+    }
+  }
+
+  // Buffers are copied into fresh allocations for the call and their
+  // final contents returned, in argument order, as the reply data.
+  agent.ops['native:call'] = function (args, data) {
+    var input = new Uint8Array(data || new ArrayBuffer(0));
+    var offset = 0;
+    var buffers = [];
+    var types = [];
+    var values = [];
+    args.args.forEach(function (a) {
+      switch (a.type) {
+        case 'int64':
+          types.push('int64');
+          values.push(int64(a.value));
+          break;
+        case 'pointer':
+          types.push('pointer');
+          values.push(ptr(a.value));
+          break;
+        case 'double':
+          types.push('double');
+          values.push(a.value);
+          break;
+        case 'buffer':
+          var mem = Memory.alloc(Math.max(a.size, 1));
+          mem.writeByteArray(input.slice(offset, offset + a.size).buffer);
+          offset += a.size;
+          buffers.push({ mem: mem, size: a.size });
+          types.push('pointer');
+          values.push(mem);
+          break;
+        default:
+          throw new Error('unsupported argument type ' + a.type);
+      }
+    });
+
+    var fn = new NativeFunction(ptr(args.address), args.retType, types);
+    var ret = fn.apply(null, values);
+    var size = buffers.reduce(function (n, b) { return n + b.size; }, 0);
+    var out = new Uint8Array(size);
+    var off = 0;
+    buffers.forEach(function (b) {
+      out.set(new Uint8Array(b.mem.readByteArray(b.size)), off);
+      off += b.size;
+    });
+    return agent.withData(encode(args.retType, ret), out.buffer);
+  };
+})();
@@ -0,0 +1,51 @@
+(function () {
+  agent.ops['scan:ranges'] = function (args) {
+    var ranges = args.module
+      ? Process.getModuleByName(args.module).enumerateRanges(args.protection)
+      : Process.enumerateRanges({ protection: args.protection, coalesce: false });
+    return ranges.map(function (r) {
+      return {
+        base: r.base.toString(),
+        size: r.size,
+        protection: r.protection,
+        file: r.file ? r.file.path : ''
+      };
+    });
+  };
+
+  // Ranges are scanned one after the other, reporting each match and the
+  // end of each range, until done or cancelled by the host.
+  agent.ops['scan:run'] = function (args, data, id) {
+    var ranges = args.ranges;
+    var total = 0;
+
+    function scanRange(index) {
+      if (index >= ranges.length || agent.cancelled(id))
+        return Promise.resolve(total);
+      var r = ranges[index];
+      var matches = 0;
+      return new Promise(function (resolve) {
+        Memory.scan(ptr(r.base), r.size, args.pattern, {
+          onMatch: function (address, size) {
+            matches++;
+            agent.emit(id, { type: 'match', range: index, address: address.toString(), size: size });
+            if (agent.cancelled(id))
+              return 'stop';
+          },
+          onError: function (reason) {
+            agent.emit(id, { type: 'error', range: index, error: reason });
+          },
+          onComplete: function () {
+            total += matches;
+            agent.emit(id, { type: 'progress', range: index, matches: matches });
+            resolve();
+          }
+        });
+      }).then(function () {
+        return scanRange(index + 1);
+      });
+    }
+
+    return scanRange(0);
+  };
+})();
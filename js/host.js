@@ -0,0 +1,28 @@
+var host = (function () {
+  var pending = {};
+  var nextId = 1;
+
+  function onReply(message) {
+    recv('frida:host', onReply);
+    var p = pending[message.id];
+    if (p === undefined)
+      return;
+    delete pending[message.id];
+    if (message.error !== undefined)
+      p.reject(new Error(message.error));
+    else
+      p.resolve(message.result);
+  }
+  recv('frida:host', onReply);
+
+  return {
+    call: function (name) {
+      var args = Array.prototype.slice.call(arguments, 1);
+      var id = nextId++;
+      return new Promise(function (resolve, reject) {
+        pending[id] = { resolve: resolve, reject: reject };
+        send(['frida:host', id, 'call', name, args]);
+      });
+    }
+  };
+})();
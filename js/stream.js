@@ -0,0 +1,279 @@
+var stream, upload;
+(function () {
+  var CHUNK_SIZE = 64 * 1024;
+  var WINDOW = 8;
+  var streams = {};
+  var nextId = 1;
+
+  var table = (function () {
+    var t = new Uint32Array(256);
+    for (var n = 0; n < 256; n++) {
+      var c = n;
+      for (var k = 0; k < 8; k++)
+        c = (c & 1) ? (0xedb88320 ^ (c >>> 1)) : (c >>> 1);
+      t[n] = c >>> 0;
+    }
+    return t;
+  })();
+
+  function crc32(crc, bytes) {
+    crc = (crc ^ 0xffffffff) >>> 0;
+    for (var i = 0; i < bytes.length; i++)
+      crc = (table[(crc ^ bytes[i]) & 0xff] ^ (crc >>> 8)) >>> 0;
+    return (crc ^ 0xffffffff) >>> 0;
+  }
+
+  function toBytes(data) {
+    if (data instanceof ArrayBuffer)
+      return new Uint8Array(data);
+    return new Uint8Array(data.buffer, data.byteOffset, data.byteLength);
+  }
+
+  function onAck(message) {
+    recv('frida:stream:ack', onAck);
+    var s = streams[message.id];
+    if (s === undefined)
+      return;
+    if (message.abort)
+      s.aborted = new Error('stream closed by host');
+    else
+      s.inflight--;
+    s.wake();
+  }
+  recv('frida:stream:ack', onAck);
+
+  function Stream(label, metadata) {
+    this.id = nextId++;
+    this.seq = 0;
+    this.inflight = 0;
+    this.total = 0;
+    this.crc = 0;
+    this.aborted = null;
+    this.waiters = [];
+    streams[this.id] = this;
+    send(['frida:stream', 'open', this.id, label, metadata === undefined ? null : metadata]);
+  }
+
+  Stream.prototype.wake = function () {
+    var waiters = this.waiters;
+    this.waiters = [];
+    waiters.forEach(function (resolve) { resolve(); });
+  };
+
+  Stream.prototype.wait = function () {
+    var self = this;
+    return new Promise(function (resolve) { self.waiters.push(resolve); });
+  };
+
+  Stream.prototype.sendChunk = function (chunk) {
+    var self = this;
+    if (self.aborted !== null)
+      return Promise.reject(self.aborted);
+    if (self.inflight >= WINDOW)
+      return self.wait().then(function () { return self.sendChunk(chunk); });
+    self.crc = crc32(self.crc, chunk);
+    self.total += chunk.length;
+    self.inflight++;
+    send(['frida:stream', 'data', self.id, self.seq++, crc32(0, chunk)],
+        chunk.buffer.slice(chunk.byteOffset, chunk.byteOffset + chunk.byteLength));
+    return Promise.resolve();
+  };
+
+  // write sends an ArrayBuffer or typed array, resolving once it is queued
+  // within the host's acknowledgement window.
+  Stream.prototype.write = function (data) {
+    var self = this;
+    var bytes = toBytes(data);
+    var offset = 0;
+    function next() {
+      if (offset >= bytes.length)
+        return Promise.resolve();
+      var chunk = bytes.subarray(offset, offset + CHUNK_SIZE);
+      offset += chunk.length;
+      return self.sendChunk(chunk).then(next);
+    }
+    return next();
+  };
+
+  // writeMemory streams size bytes starting at address, reading one chunk
+  // at a time so the region is never buffered as a whole.
+  Stream.prototype.writeMemory = function (address, size) {
+    var self = this;
+    var base = ptr(address);
+    var offset = 0;
+    function next() {
+      if (offset >= size)
+        return Promise.resolve();
+      var n = Math.min(CHUNK_SIZE, size - offset);
+      var chunk = new Uint8Array(base.add(offset).readByteArray(n));
+      offset += n;
+      return self.sendChunk(chunk).then(next);
+    }
+    return next();
+  };
+
+  Stream.prototype.end = function () {
+    var self = this;
+    function drain() {
+      if (self.aborted !== null)
+        return Promise.reject(self.aborted);
+      if (self.inflight > 0)
+        return self.wait().then(drain);
+      delete streams[self.id];
+      send(['frida:stream', 'end', self.id, self.total, self.crc]);
+      return Promise.resolve();
+    }
+    return drain();
+  };
+
+  Stream.prototype.abort = function (reason) {
+    delete streams[this.id];
+    send(['frida:stream', 'abort', this.id, String(reason)]);
+  };
+
+  stream = {
+    open: function (label, metadata) {
+      return new Stream(label, metadata);
+    }
+  };
+
+  var acceptors = {};
+  var uploads = {};
+  // Uploads opened before upload.accept, by label. Their messages are held
+  // unacknowledged, which also holds the host back, until accepted.
+  var parked = {};
+  var parkedById = {};
+
+  function onUpload(message, data) {
+    recv('frida:upload', onUpload);
+    handleUpload(message, data);
+  }
+  recv('frida:upload', onUpload);
+
+  function handleUpload(message, data) {
+    var p = parkedById[message.id];
+    if (p !== undefined) {
+      if (message.op === 'abort') {
+        delete parkedById[message.id];
+        var waiting = parked[p.label];
+        waiting.splice(waiting.indexOf(p), 1);
+        if (waiting.length === 0)
+          delete parked[p.label];
+      } else {
+        p.backlog.push([message, data]);
+      }
+      return;
+    }
+
+    var u = uploads[message.id];
+    try {
+      switch (message.op) {
+        case 'open':
+          var queue = acceptors[message.label];
+          if (queue === undefined || queue.length === 0) {
+            p = { id: message.id, label: message.label, backlog: [] };
+            (parked[message.label] || (parked[message.label] = [])).push(p);
+            parkedById[message.id] = p;
+            return;
+          }
+          var acceptor = queue.shift();
+          if (queue.length === 0)
+            delete acceptors[message.label];
+          uploads[message.id] = new Upload(message.id, acceptor);
+          break;
+        case 'data':
+          if (u === undefined)
+            return;
+          u.write(message.crc, data);
+          send(['frida:upload', 'ack', message.id, message.seq]);
+          break;
+        case 'end':
+          if (u !== undefined)
+            u.finish(message.size, message.crc);
+          break;
+        case 'abort':
+          if (u !== undefined)
+            u.fail(new Error('upload aborted by host'), false);
+          break;
+      }
+    } catch (e) {
+      if (u !== undefined)
+        u.fail(e, true);
+    }
+  }
+
+  function Upload(id, acceptor) {
+    this.id = id;
+    this.acceptor = acceptor;
+    this.sink = acceptor.sink || {};
+    this.offset = 0;
+    this.crc = 0;
+    this.chunks = [];
+    this.file = (this.sink.path !== undefined) ? new File(this.sink.path, 'wb') : null;
+  }
+
+  Upload.prototype.write = function (crc, data) {
+    var bytes = new Uint8Array(data);
+    if (crc32(0, bytes) !== crc)
+      throw new Error('upload checksum mismatch');
+    if (this.sink.address !== undefined)
+      ptr(this.sink.address).add(this.offset).writeByteArray(data);
+    else if (this.file !== null)
+      this.file.write(data);
+    else
+      this.chunks.push(bytes);
+    this.offset += bytes.length;
+    this.crc = crc32(this.crc, bytes);
+  };
+
+  Upload.prototype.finish = function (size, crc) {
+    if (size !== this.offset || crc !== this.crc)
+      throw new Error('upload checksum mismatch');
+    delete uploads[this.id];
+    var result;
+    if (this.sink.address !== undefined) {
+      result = ptr(this.sink.address);
+    } else if (this.file !== null) {
+      this.file.close();
+      result = this.sink.path;
+    } else {
+      var buf = new Uint8Array(this.offset);
+      var pos = 0;
+      this.chunks.forEach(function (c) { buf.set(c, pos); pos += c.length; });
+      result = buf.buffer;
+    }
+    send(['frida:upload', 'done', this.id, null]);
+    this.acceptor.resolve(result);
+  };
+
+  Upload.prototype.fail = function (error, notify) {
+    delete uploads[this.id];
+    if (this.file !== null)
+      this.file.close();
+    if (notify)
+      send(['frida:upload', 'done', this.id, error.message]);
+    this.acceptor.reject(error);
+  };
+
+  upload = {
+    // accept resolves with the next upload sent under label: an ArrayBuffer
+    // by default, or written to sink.address or sink.path as it arrives.
+    accept: function (label, sink) {
+      return new Promise(function (resolve, reject) {
+        var acceptor = { sink: sink, resolve: resolve, reject: reject };
+        var queue = parked[label];
+        if (queue === undefined) {
+          queue = acceptors[label] || (acceptors[label] = []);
+          queue.push(acceptor);
+          return;
+        }
+        var p = queue.shift();
+        if (queue.length === 0)
+          delete parked[label];
+        delete parkedById[p.id];
+        uploads[p.id] = new Upload(p.id, acceptor);
+        p.backlog.forEach(function (m) { handleUpload(m[0], m[1]); });
+      });
+    }
+  };
+})();